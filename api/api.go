@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/nodestats/p2p"
+	"github.com/nodestats/stats"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// Server exposes the crawler state as a JSON HTTP API
+type Server struct {
+	cmn.BaseService
+
+	laddr    string
+	sw       *p2p.Switch
+	addrBook *p2p.AddrBook
+	store    *stats.Store
+	server   *http.Server
+}
+
+// NewServer creates an API server listening on laddr
+func NewServer(laddr string, sw *p2p.Switch, addrBook *p2p.AddrBook, store *stats.Store) *Server {
+	s := &Server{
+		laddr:    laddr,
+		sw:       sw,
+		addrBook: addrBook,
+		store:    store,
+	}
+	s.server = &http.Server{Handler: s.buildHandler()}
+	s.BaseService = *cmn.NewBaseService(nil, "API Server", s)
+	return s
+}
+
+func (s *Server) buildHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/peers", s.handlePeers)
+	mux.HandleFunc("/addrbook", s.handleAddrBook)
+	mux.HandleFunc("/nodes", s.handleNodes)
+	mux.HandleFunc("/nodes/", s.handleNode)
+	mux.HandleFunc("/stats", s.handleStats)
+	mux.HandleFunc("/versions", s.handleVersions)
+	mux.HandleFunc("/graph", s.handleGraph)
+	mux.HandleFunc("/availability", s.handleAvailability)
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+// OnStart implements BaseService
+func (s *Server) OnStart() error {
+	listener, err := net.Listen("tcp", s.laddr)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("laddr", s.laddr).Info("API server listening")
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.WithField("err", err).Error("API server stopped")
+		}
+	}()
+	return nil
+}
+
+// OnStop implements BaseService
+func (s *Server) OnStop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		log.WithField("err", err).Warn("API server shutdown")
+	}
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithField("err", err).Error("fail to write API response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &errorResponse{Error: err.Error()})
+}
+
+// parseSince reads the optional window query parameter, such as "24h", and
+// returns the earliest time to include. Without it everything is included.
+func parseSince(r *http.Request) (time.Time, error) {
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		return time.Time{}, nil
+	}
+
+	d, err := time.ParseDuration(window)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(-d), nil
+}
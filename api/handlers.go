@@ -0,0 +1,240 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nodestats/p2p"
+	"github.com/nodestats/p2p/connection"
+	"github.com/nodestats/stats"
+)
+
+type peerResponse struct {
+	Key              string                      `json:"key"`
+	NodeInfo         *p2p.NodeInfo               `json:"node_info"`
+	Outbound         bool                        `json:"outbound"`
+	ConnectionStatus connection.ConnectionStatus `json:"connection_status"`
+}
+
+type peersResponse struct {
+	Outbound int             `json:"outbound"`
+	Inbound  int             `json:"inbound"`
+	Dialing  int             `json:"dialing"`
+	Peers    []*peerResponse `json:"peers"`
+}
+
+type addrBookResponse struct {
+	Size    int                  `json:"size"`
+	Status  *p2p.AddrBookStatus  `json:"status"`
+	Entries []*p2p.AddrBookEntry `json:"entries"`
+}
+
+type nodeResponse struct {
+	Node        *stats.NodeRecord   `json:"node"`
+	Connections []*stats.ConnRecord `json:"connections"`
+}
+
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	resp := &peersResponse{Peers: []*peerResponse{}}
+	resp.Outbound, resp.Inbound, resp.Dialing = s.sw.NumPeers()
+	for _, peer := range s.sw.Peers().List() {
+		resp.Peers = append(resp.Peers, &peerResponse{
+			Key:              peer.Key,
+			NodeInfo:         peer.NodeInfo,
+			Outbound:         peer.IsOutbound(),
+			ConnectionStatus: peer.Status(),
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleAddrBook(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &addrBookResponse{
+		Size:    s.addrBook.Size(),
+		Status:  s.addrBook.Status(),
+		Entries: s.addrBook.Entries(),
+	})
+}
+
+func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
+	since, err := parseSince(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	nodes, err := s.store.ListNodes()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	result := []*stats.NodeRecord{}
+	for _, node := range nodes {
+		if !node.LastSeen.Before(since) {
+			result = append(result, node)
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleNode(w http.ResponseWriter, r *http.Request) {
+	pubKey := strings.TrimPrefix(r.URL.Path, "/nodes/")
+	if pubKey == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing node pubkey"))
+		return
+	}
+
+	node, err := s.store.GetNode(pubKey)
+	if err == stats.ErrNotFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	conns, err := s.store.ListConnections(pubKey)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &nodeResponse{Node: node, Connections: conns})
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	since, err := parseSince(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	nodes, err := s.store.ListNodes()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats.Aggregate(nodes, since, s.groupKey))
+}
+
+// handleVersions serves the version report of the nodes on our network, the
+// optional active, days and min_major query parameters override the defaults.
+func (s *Server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	opts := stats.DefaultVersionReportOptions()
+	opts.Network = s.sw.NodeInfo().Network
+	query := r.URL.Query()
+	if active := query.Get("active"); active != "" {
+		d, err := time.ParseDuration(active)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		opts.Active = d
+	}
+	if days := query.Get("days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("days must be a positive integer"))
+			return
+		}
+		opts.Days = n
+	}
+	if minMajor := query.Get("min_major"); minMajor != "" {
+		n, err := strconv.ParseUint(minMajor, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		opts.MinMajor = n
+	}
+
+	nodes, err := s.store.ListNodes()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats.BuildVersionReport(nodes, opts))
+}
+
+var graphContentTypes = map[string]string{
+	stats.GraphFormatDOT:  "text/vnd.graphviz",
+	stats.GraphFormatGEXF: "application/xml",
+	stats.GraphFormatJSON: "application/json",
+}
+
+// handleGraph exports the topology graph, format is one of dot, gexf or json
+// and defaults to json.
+func (s *Server) handleGraph(w http.ResponseWriter, r *http.Request) {
+	since, err := parseSince(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = stats.GraphFormatJSON
+	}
+	contentType, ok := graphContentTypes[format]
+	if !ok {
+		writeError(w, http.StatusBadRequest, stats.ErrUnknownGraphFormat)
+		return
+	}
+
+	adverts, err := s.store.ListAdvertised()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	nodes, err := s.store.ListNodes()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if err := stats.BuildGraph(adverts, nodes, since).Write(w, format); err != nil {
+		log.WithField("err", err).Error("fail to write graph")
+	}
+}
+
+// handleAvailability ranks the dialed addresses by their availability over the
+// period query parameter, one of the availability window names such as 1d, the
+// default. Unlike the window parameter of the other endpoints it isn't a
+// duration. Addresses with fewer decayed attempts than min_attempts are left out.
+func (s *Server) handleAvailability(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	window := query.Get("period")
+	if window == "" {
+		window = "1d"
+	}
+	if err := stats.ValidAvailabilityWindow(window); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	minAttempts := 0.0
+	if v := query.Get("min_attempts"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		minAttempts = f
+	}
+
+	addrs, err := s.store.ListAddrs()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats.RankByAvailability(addrs, window, minAttempts))
+}
+
+func (s *Server) groupKey(ip net.IP) string {
+	return s.addrBook.GroupKey(p2p.NewNetAddressIPPort(ip, 0))
+}
@@ -0,0 +1,87 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/nodestats/stats"
+)
+
+// availabilityCmd ranks the dialed addresses by availability
+var availabilityCmd = &cobra.Command{
+	Use:   "availability",
+	Short: "Rank the dialed addresses by their availability over a time window",
+	RunE:  runAvailability,
+}
+
+func init() {
+	addStoreFlags(availabilityCmd)
+	availabilityCmd.Flags().String("period", "1d", "availability window: 2h, 8h, 1d, 7d or 30d")
+	availabilityCmd.Flags().Float64("min_attempts", 0, "leave out addresses with fewer decayed attempts in the window")
+	availabilityCmd.Flags().Int("limit", 0, "print at most this many addresses, 0 prints all")
+	availabilityCmd.Flags().Bool("json", false, "print the records as JSON")
+	rootCmd.AddCommand(availabilityCmd)
+}
+
+func runAvailability(cmd *cobra.Command, args []string) error {
+	window, _ := cmd.Flags().GetString("period")
+	minAttempts, _ := cmd.Flags().GetFloat64("min_attempts")
+	limit, _ := cmd.Flags().GetInt("limit")
+	asJSON, _ := cmd.Flags().GetBool("json")
+	if err := stats.ValidAvailabilityWindow(window); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	store, db := openStore(config)
+	defer db.Close()
+
+	addrs, err := store.ListAddrs()
+	if err != nil {
+		return errors.Wrap(err, "list addresses")
+	}
+	ranked := stats.RankByAvailability(addrs, window, minAttempts)
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(ranked)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "ADDRESS\tSCORE\tATTEMPTS\tLAST SUCCESS\n")
+	for _, a := range ranked {
+		stat := a.Availability[window]
+		lastSuccess := "never"
+		if !a.LastSuccess.IsZero() {
+			lastSuccess = a.LastSuccess.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%.3f\t%.1f\t%s\n", a.Addr, stat.Score(), stat.Count, lastSuccess)
+	}
+	return w.Flush()
+}
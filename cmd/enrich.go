@@ -0,0 +1,128 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	cfg "github.com/nodestats/config"
+	"github.com/nodestats/geoip"
+	"github.com/nodestats/stats"
+)
+
+// enrichCmd locates the recorded node IPs with the geoip databases
+var enrichCmd = &cobra.Command{
+	Use:   "enrich",
+	Short: "Locate the recorded node IPs and report nodes per country, ASN and hosting provider",
+	RunE:  runEnrich,
+}
+
+func init() {
+	defaults := cfg.DefaultP2PConfig()
+	addStoreFlags(enrichCmd)
+	enrichCmd.Flags().String("geoip_city_db", defaults.GeoIPCityDB, "MaxMind format city database, relative to home")
+	enrichCmd.Flags().String("geoip_asn_db", defaults.GeoIPASNDB, "MaxMind format ASN database, relative to home")
+	enrichCmd.Flags().Bool("force", false, "locate every IP again, after a database update")
+	enrichCmd.Flags().Duration("active", 24*time.Hour, "nodes seen within this duration count in the report")
+	enrichCmd.Flags().Int("top", 20, "number of ASNs in the report")
+	rootCmd.AddCommand(enrichCmd)
+}
+
+func runEnrich(cmd *cobra.Command, args []string) error {
+	force, _ := cmd.Flags().GetBool("force")
+	active, _ := cmd.Flags().GetDuration("active")
+	top, _ := cmd.Flags().GetInt("top")
+
+	config, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	resolver, err := geoip.Open(config.GeoIPCityDBFile(), config.GeoIPASNDBFile())
+	if err != nil {
+		return errors.Wrap(err, "open geoip databases")
+	}
+	defer resolver.Close()
+
+	store, db := openStore(config)
+	defer db.Close()
+
+	located, err := store.Enrich(resolver, force)
+	if err != nil {
+		return errors.Wrap(err, "enrich nodes")
+	}
+	log.WithField("ips", located).Info("Located node IPs")
+
+	nodes, err := store.ListNodes()
+	if err != nil {
+		return errors.Wrap(err, "list nodes")
+	}
+	printGeoReport(stats.Aggregate(nodes, time.Now().Add(-active), nil), top)
+	return nil
+}
+
+type geoCount struct {
+	key   string
+	nodes int
+}
+
+// sortedCounts returns the entries of counts by decreasing number of nodes
+func sortedCounts(counts map[string]int) []geoCount {
+	sorted := make([]geoCount, 0, len(counts))
+	for k, n := range counts {
+		sorted = append(sorted, geoCount{k, n})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].nodes != sorted[j].nodes {
+			return sorted[i].nodes > sorted[j].nodes
+		}
+		return sorted[i].key < sorted[j].key
+	})
+	return sorted
+}
+
+func printGeoReport(summary *stats.Summary, top int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "COUNTRY\tNODES\n")
+	for _, c := range sortedCounts(summary.ByCountry) {
+		fmt.Fprintf(w, "%s\t%d\n", c.key, c.nodes)
+	}
+	fmt.Fprintf(w, "unlocated\t%d\n", summary.Unlocated)
+
+	fmt.Fprintf(w, "\nASN\tNODES\tHOSTING\n")
+	for i, c := range sortedCounts(summary.ByASN) {
+		if i == top {
+			break
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", c.key, c.nodes, stats.HostingProvider(c.key))
+	}
+
+	hosting := summary.Hosting
+	fmt.Fprintf(w, "\nPROVIDER\tNODES\n")
+	for _, c := range sortedCounts(hosting.ByProvider) {
+		fmt.Fprintf(w, "%s\t%d\n", c.key, c.nodes)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d nodes (%.1f%%) in hosting providers, largest ASN %s with %.1f%%, HHI %.0f\n",
+		hosting.Nodes, hosting.Percent, hosting.TopASN, hosting.TopPercent, hosting.HHI)
+}
@@ -0,0 +1,65 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/nodestats/stats"
+)
+
+// graphCmd exports the network topology learned from PEX responses
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Export the network topology graph as DOT, GEXF or JSON adjacency lists",
+	RunE:  runGraph,
+}
+
+func init() {
+	addStoreFlags(graphCmd)
+	graphCmd.Flags().String("format", stats.GraphFormatDOT, "output format: dot, gexf or json")
+	graphCmd.Flags().Duration("window", 0, "only include addresses advertised within this duration, 0 for all")
+	rootCmd.AddCommand(graphCmd)
+}
+
+func runGraph(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	window, _ := cmd.Flags().GetDuration("window")
+	var since time.Time
+	if window > 0 {
+		since = time.Now().Add(-window)
+	}
+
+	config, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	store, db := openStore(config)
+	defer db.Close()
+
+	adverts, err := store.ListAdvertised()
+	if err != nil {
+		return errors.Wrap(err, "list advertised addresses")
+	}
+	nodes, err := store.ListNodes()
+	if err != nil {
+		return errors.Wrap(err, "list nodes")
+	}
+	return stats.BuildGraph(adverts, nodes, since).Write(os.Stdout, format)
+}
@@ -17,10 +17,15 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	homedir "github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	cfg "github.com/nodestats/config"
+	"github.com/nodestats/node"
 )
 
 var cfgFile string
@@ -54,7 +59,62 @@ func init() {
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
-	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	defaults := cfg.DefaultP2PConfig()
+	rootCmd.Flags().String("home", defaultHomeDir(), "root directory for node key, address book and data")
+	rootCmd.Flags().String("moniker", defaults.Moniker, "node name advertised to peers")
+	rootCmd.Flags().String("network", defaults.Network, "network name that peers must match")
+	rootCmd.Flags().String("version", defaults.Version, "node version advertised to peers")
+	rootCmd.Flags().String("node_key_file", defaults.NodeKey, "node private key file, relative to home")
+	rootCmd.Flags().String("db_backend", defaults.DBBackend, "database backend for the node history")
+	rootCmd.Flags().String("db_dir", defaults.DBPath, "database directory, relative to home")
+	rootCmd.Flags().String("laddr", defaults.ListenAddress, "p2p listen address")
+	rootCmd.Flags().String("seeds", defaults.Seeds, "comma delimited host:port seed nodes")
+	rootCmd.Flags().Bool("skip_upnp", defaults.SkipUPNP, "skip UPnP and NAT-PMP port mapping on the gateway")
+	rootCmd.Flags().String("addr_book_file", defaults.AddrBook, "address book file, relative to home")
+	rootCmd.Flags().Bool("addr_book_strict", defaults.AddrBookStrict, "only accept routable addresses into the address book")
+	rootCmd.Flags().Int("addr_book_save_interval", defaults.AddrBookSave, "seconds between two saves of the address book")
+	rootCmd.Flags().Bool("pex", defaults.PexReactor, "enable the peer exchange reactor")
+	rootCmd.Flags().Bool("crawl", defaults.CrawlMode, "sweep the whole address book instead of keeping a few outbound peers")
+	rootCmd.Flags().Int("crawl_concurrency", defaults.CrawlConcurrency, "max number of concurrent crawl dials")
+	rootCmd.Flags().Int("crawl_interval", defaults.CrawlInterval, "seconds between two crawls of the same address")
+	rootCmd.Flags().Bool("seed_mode", defaults.SeedMode, "serve inbound peers one address sample then disconnect them, implies crawl")
+	rootCmd.Flags().Bool("observe_incompatible", defaults.ObserveIncompatible, "record the node info of peers rejected as incompatible, nodes of other networks are not banned")
+	rootCmd.Flags().String("version_policy", defaults.VersionPolicy, "peer version compatibility: exact_minor, same_major or allow_list")
+	rootCmd.Flags().String("version_allow_list", defaults.VersionAllowList, "version ranges accepted by the allow_list policy, e.g. \">=1.0.0 <1.2.0 || 1.3.0\"")
+	rootCmd.Flags().Int("max_num_peers", defaults.MaxNumPeers, "max number of connected peers")
+	rootCmd.Flags().Int("max_num_inbound_peers", defaults.MaxNumInboundPeers, "max number of inbound peers")
+	rootCmd.Flags().Int("max_num_outbound_peers", defaults.MaxNumOutboundPeers, "max number of outbound peers")
+	rootCmd.Flags().Int("max_peers_per_ip_group", defaults.MaxPeersPerIPGroup, "max number of peers in one /16 (IPv4) or /32 (IPv6)")
+	rootCmd.Flags().String("reserved_peers", defaults.ReservedPeers, "comma delimited node pubkeys always admitted")
+	rootCmd.Flags().Bool("dedupe_ip", defaults.DedupeIP, "refuse a peer whose IP is already connected, nodes sharing an IP then count once")
+	rootCmd.Flags().Int("handshake_timeout", defaults.HandshakeTimeout, "peer handshake timeout in seconds")
+	rootCmd.Flags().Int("dial_timeout", defaults.DialTimeout, "peer dial timeout in seconds")
+	rootCmd.Flags().Int("ping_interval", defaults.PingInterval, "seconds between two pings measuring the round trip time to a peer")
+	rootCmd.Flags().Int("pong_timeout", defaults.PongTimeout, "seconds to wait for the reply to a ping before disconnecting the peer")
+	rootCmd.Flags().Int("ban_duration", defaults.BanDuration, "seconds a misbehaving or wrong network peer stays banned")
+	rootCmd.Flags().String("api_laddr", defaults.APIAddress, "HTTP API listen address, empty to disable")
+	rootCmd.Flags().String("dnsseed_laddr", defaults.DNSSeedAddress, "DNS seeder listen address (udp and tcp), empty to disable")
+	rootCmd.Flags().String("dnsseed_zone", defaults.DNSSeedZone, "zone the DNS seeder is authoritative for, e.g. seed.example.com")
+	rootCmd.Flags().String("dnsseed_ns", defaults.DNSSeedNS, "name server of the DNS seeder zone")
+	rootCmd.Flags().Int("dnsseed_ttl", defaults.DNSSeedTTL, "TTL in seconds of the DNS seeder answers")
+	rootCmd.Flags().Int("dnsseed_max_answers", defaults.DNSSeedMaxAnswers, "max number of addresses in a DNS seeder answer")
+	rootCmd.Flags().Int("dnsseed_max_age", defaults.DNSSeedMaxAge, "seconds since the last successful dial for a node to be served")
+	rootCmd.Flags().Float64("dnsseed_min_availability", defaults.DNSSeedMinAvailability, "minimum 1d availability score, between 0 and 1, for a node to be served")
+	rootCmd.Flags().String("geoip_city_db", defaults.GeoIPCityDB, "MaxMind format city database locating node IPs, relative to home")
+	rootCmd.Flags().String("geoip_asn_db", defaults.GeoIPASNDB, "MaxMind format ASN database mapping node IPs to their network, relative to home")
+
+	if err := viper.BindPFlags(rootCmd.Flags()); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func defaultHomeDir() string {
+	home, err := homedir.Dir()
+	if err != nil {
+		return ".nodestats"
+	}
+	return filepath.Join(home, ".nodestats")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -75,6 +135,7 @@ func initConfig() {
 		viper.SetConfigName(".nodestats")
 	}
 
+	viper.SetEnvPrefix("nodestats")
 	viper.AutomaticEnv() // read in environment variables that match
 
 	// If a config file is found, read it in.
@@ -84,5 +145,20 @@ func initConfig() {
 }
 
 func runNode(cmd *cobra.Command, args []string) {
-	//n := node.NewNode(config)
+	config := cfg.DefaultP2PConfig()
+	if err := viper.Unmarshal(config); err != nil {
+		log.WithField("err", err).Fatal("failed to parse config")
+	}
+
+	n, err := node.NewNode(config)
+	if err != nil {
+		log.WithField("err", err).Fatal("failed to create node")
+	}
+
+	if _, err := n.Start(); err != nil {
+		log.WithField("err", err).Fatal("failed to start node")
+	}
+	log.WithFields(log.Fields{"home": config.RootDir, "laddr": config.ListenAddress}).Info("node started")
+
+	n.RunForever()
 }
@@ -0,0 +1,54 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	dbm "github.com/tendermint/tmlibs/db"
+
+	cfg "github.com/nodestats/config"
+	"github.com/nodestats/stats"
+)
+
+// addStoreFlags adds the flags locating the node database to a subcommand
+func addStoreFlags(cmd *cobra.Command) {
+	defaults := cfg.DefaultP2PConfig()
+	cmd.Flags().String("home", defaultHomeDir(), "root directory for node key, address book and data")
+	cmd.Flags().String("db_backend", defaults.DBBackend, "database backend for the node history")
+	cmd.Flags().String("db_dir", defaults.DBPath, "database directory, relative to home")
+}
+
+// loadConfig reads the config the way the node does, from the config file and
+// the NODESTATS_ environment variables. The flags of cmd given on the command
+// line take precedence.
+func loadConfig(cmd *cobra.Command) (*cfg.P2PConfig, error) {
+	if err := viper.BindPFlags(cmd.Flags()); err != nil {
+		return nil, err
+	}
+	config := cfg.DefaultP2PConfig()
+	if err := viper.Unmarshal(config); err != nil {
+		return nil, errors.Wrap(err, "parse config")
+	}
+	return config, nil
+}
+
+// openStore opens the node database directly. With the leveldb backend the
+// node must not be running, use the API instead.
+func openStore(config *cfg.P2PConfig) (*stats.Store, dbm.DB) {
+	db := dbm.NewDB("nodestats", config.DBBackend, config.DBDir())
+	return stats.NewStore(db), db
+}
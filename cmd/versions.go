@@ -0,0 +1,107 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	cfg "github.com/nodestats/config"
+	"github.com/nodestats/stats"
+)
+
+// versionsCmd prints the version distribution and upgrade adoption report
+var versionsCmd = &cobra.Command{
+	Use:   "versions",
+	Short: "Report the versions run by the observed nodes and their adoption",
+	RunE:  runVersions,
+}
+
+func init() {
+	defaults := stats.DefaultVersionReportOptions()
+	addStoreFlags(versionsCmd)
+	versionsCmd.Flags().Duration("active", defaults.Active, "nodes seen within this duration count in the current distribution")
+	versionsCmd.Flags().String("network", cfg.DefaultP2PConfig().Network, "only count the nodes of this network, empty counts every node")
+	versionsCmd.Flags().Int("days", defaults.Days, "days of history")
+	versionsCmd.Flags().Uint64("min_major", defaults.MinMajor, "majors below are deprecated, 0 uses the highest current major")
+	versionsCmd.Flags().Bool("json", false, "print the full report as JSON")
+	rootCmd.AddCommand(versionsCmd)
+}
+
+func runVersions(cmd *cobra.Command, args []string) error {
+	opts := stats.DefaultVersionReportOptions()
+	opts.Active, _ = cmd.Flags().GetDuration("active")
+	opts.Days, _ = cmd.Flags().GetInt("days")
+	opts.MinMajor, _ = cmd.Flags().GetUint64("min_major")
+	asJSON, _ := cmd.Flags().GetBool("json")
+	if opts.Days <= 0 {
+		return errors.New("days must be a positive integer")
+	}
+
+	config, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	opts.Network = config.Network
+	store, db := openStore(config)
+	defer db.Close()
+
+	nodes, err := store.ListNodes()
+	if err != nil {
+		return errors.Wrap(err, "list nodes")
+	}
+	report := stats.BuildVersionReport(nodes, opts)
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	printVersionReport(report)
+	return nil
+}
+
+func printVersionReport(report *stats.VersionReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "VERSION\tNODES\tPERCENT\n")
+	for _, c := range report.Current {
+		fmt.Fprintf(w, "%s\t%d\t%.1f%%\n", c.Version, c.Nodes, c.Percent)
+	}
+	fmt.Fprintf(w, "total\t%d\t\n", report.Total)
+	fmt.Fprintf(w, "majors below %d\t%d\t%.1f%%\n", report.MinMajor, report.Deprecated, report.DeprecatedPercent)
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "VERSION\tFIRST SEEN\tDAY 1\tDAY 7\tDAY 30\tLATEST\n")
+	for _, curve := range report.Adoption {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", curve.Version, curve.FirstSeen.Format(time.RFC3339),
+			adoptionAt(curve, 1), adoptionAt(curve, 7), adoptionAt(curve, 30), adoptionAt(curve, len(curve.Points)-1))
+	}
+	w.Flush()
+}
+
+func adoptionAt(curve *stats.AdoptionCurve, day int) string {
+	if day < 0 || day >= len(curve.Points) {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", curve.Points[day].Percent)
+}
@@ -1,5 +1,9 @@
 package config
 
+import (
+	"path/filepath"
+)
+
 type Config struct {
 	RootDir          string `mapstructure:"home"`
 	ListenAddress    string `mapstructure:"laddr"`
@@ -26,28 +30,121 @@ func DefaultConfig() *Config {
 
 // P2PConfig
 type P2PConfig struct {
-	RootDir          string `mapstructure:"home"`
-	ListenAddress    string `mapstructure:"laddr"`
-	Seeds            string `mapstructure:"seeds"`
-	SkipUPNP         bool   `mapstructure:"skip_upnp"`
-	AddrBook         string `mapstructure:"addr_book_file"`
-	AddrBookStrict   bool   `mapstructure:"addr_book_strict"`
-	PexReactor       bool   `mapstructure:"pex"`
-	MaxNumPeers      int    `mapstructure:"max_num_peers"`
-	HandshakeTimeout int    `mapstructure:"handshake_timeout"`
-	DialTimeout      int    `mapstructure:"dial_timeout"`
+	RootDir                string  `mapstructure:"home"`
+	Moniker                string  `mapstructure:"moniker"`
+	Network                string  `mapstructure:"network"`
+	Version                string  `mapstructure:"version"`
+	NodeKey                string  `mapstructure:"node_key_file"`
+	DBBackend              string  `mapstructure:"db_backend"`
+	DBPath                 string  `mapstructure:"db_dir"`
+	ListenAddress          string  `mapstructure:"laddr"`
+	Seeds                  string  `mapstructure:"seeds"`
+	SkipUPNP               bool    `mapstructure:"skip_upnp"`
+	AddrBook               string  `mapstructure:"addr_book_file"`
+	AddrBookStrict         bool    `mapstructure:"addr_book_strict"`
+	AddrBookSave           int     `mapstructure:"addr_book_save_interval"` // seconds
+	PexReactor             bool    `mapstructure:"pex"`
+	CrawlMode              bool    `mapstructure:"crawl"`
+	CrawlConcurrency       int     `mapstructure:"crawl_concurrency"`
+	CrawlInterval          int     `mapstructure:"crawl_interval"`       // seconds between two visits of the same address
+	SeedMode               bool    `mapstructure:"seed_mode"`            // hand inbound peers an address sample and disconnect them
+	ObserveIncompatible    bool    `mapstructure:"observe_incompatible"` // record the NodeInfo of rejected handshakes
+	VersionPolicy          string  `mapstructure:"version_policy"`       // exact_minor, same_major or allow_list
+	VersionAllowList       string  `mapstructure:"version_allow_list"`   // ranges separated by "||", e.g. ">=1.0.0 <1.2.0"
+	MaxNumPeers            int     `mapstructure:"max_num_peers"`
+	MaxNumInboundPeers     int     `mapstructure:"max_num_inbound_peers"`
+	MaxNumOutboundPeers    int     `mapstructure:"max_num_outbound_peers"`
+	MaxPeersPerIPGroup     int     `mapstructure:"max_peers_per_ip_group"` // /16 for IPv4, /32 for IPv6
+	ReservedPeers          string  `mapstructure:"reserved_peers"`         // comma delimited pubkeys exempt from the limits
+	DedupeIP               bool    `mapstructure:"dedupe_ip"`              // refuse a peer whose IP is already connected
+	HandshakeTimeout       int     `mapstructure:"handshake_timeout"`
+	DialTimeout            int     `mapstructure:"dial_timeout"`
+	PingInterval           int     `mapstructure:"ping_interval"` // seconds
+	PongTimeout            int     `mapstructure:"pong_timeout"`  // seconds
+	BanDuration            int     `mapstructure:"ban_duration"`  // seconds
+	APIAddress             string  `mapstructure:"api_laddr"`     // empty disables the HTTP API
+	DNSSeedAddress         string  `mapstructure:"dnsseed_laddr"` // empty disables the DNS seeder
+	DNSSeedZone            string  `mapstructure:"dnsseed_zone"`
+	DNSSeedNS              string  `mapstructure:"dnsseed_ns"`  // name server of the zone, announced in NS and SOA records
+	DNSSeedTTL             int     `mapstructure:"dnsseed_ttl"` // seconds
+	DNSSeedMaxAnswers      int     `mapstructure:"dnsseed_max_answers"`
+	DNSSeedMaxAge          int     `mapstructure:"dnsseed_max_age"`          // seconds since the last successful dial
+	DNSSeedMinAvailability float64 `mapstructure:"dnsseed_min_availability"` // minimum 1d availability score, 0 to 1
+	GeoIPCityDB            string  `mapstructure:"geoip_city_db"`            // MMDB file, relative to home, empty disables
+	GeoIPASNDB             string  `mapstructure:"geoip_asn_db"`
 }
 
 // Default configurable p2p parameters.
 func DefaultP2PConfig() *P2PConfig {
 	return &P2PConfig{
-		ListenAddress:    "tcp://0.0.0.0:46656",
-		AddrBook:         "addrbook.json",
-		AddrBookStrict:   true,
-		SkipUPNP:         false,
-		MaxNumPeers:      50,
-		HandshakeTimeout: 30,
-		DialTimeout:      3,
-		PexReactor:       true,
+		Moniker:             "nodestats",
+		Network:             "mainnet",
+		Version:             "1.0.0",
+		NodeKey:             "node_key.txt",
+		DBBackend:           "leveldb",
+		DBPath:              "data",
+		ListenAddress:       "tcp://0.0.0.0:46656",
+		AddrBook:            "addrbook.json",
+		AddrBookStrict:      true,
+		AddrBookSave:        120,
+		SkipUPNP:            false,
+		MaxNumPeers:         50,
+		MaxNumInboundPeers:  30,
+		MaxNumOutboundPeers: 20,
+		MaxPeersPerIPGroup:  4,
+		DedupeIP:            true,
+		HandshakeTimeout:    30,
+		DialTimeout:         3,
+		PingInterval:        40,
+		PongTimeout:         30,
+		BanDuration:         86400,
+		PexReactor:          true,
+		CrawlMode:           true,
+		CrawlConcurrency:    16,
+		CrawlInterval:       600,
+		VersionPolicy:       "exact_minor",
+		APIAddress:          "127.0.0.1:46657",
+		DNSSeedTTL:          60,
+		DNSSeedMaxAnswers:   25,
+		DNSSeedMaxAge:       86400,
+	}
+}
+
+// AddrBookFile returns the full path of the address book file
+func (c *P2PConfig) AddrBookFile() string {
+	return rootify(c.AddrBook, c.RootDir)
+}
+
+// NodeKeyFile returns the full path of the node private key file
+func (c *P2PConfig) NodeKeyFile() string {
+	return rootify(c.NodeKey, c.RootDir)
+}
+
+// DBDir returns the full path of the node database directory
+func (c *P2PConfig) DBDir() string {
+	return rootify(c.DBPath, c.RootDir)
+}
+
+// GeoIPCityDBFile returns the full path of the geoip city database, "" when unset
+func (c *P2PConfig) GeoIPCityDBFile() string {
+	if c.GeoIPCityDB == "" {
+		return ""
+	}
+	return rootify(c.GeoIPCityDB, c.RootDir)
+}
+
+// GeoIPASNDBFile returns the full path of the geoip ASN database, "" when unset
+func (c *P2PConfig) GeoIPASNDBFile() string {
+	if c.GeoIPASNDB == "" {
+		return ""
+	}
+	return rootify(c.GeoIPASNDB, c.RootDir)
+}
+
+// helper function to make config creation independent of root dir
+func rootify(path, root string) string {
+	if filepath.IsAbs(path) {
+		return path
 	}
+	return filepath.Join(root, path)
 }
@@ -0,0 +1,278 @@
+package dnsseed
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+	cmn "github.com/tendermint/tmlibs/common"
+
+	cfg "github.com/nodestats/config"
+	"github.com/nodestats/p2p"
+	"github.com/nodestats/stats"
+)
+
+const (
+	refreshPeriod      = time.Minute
+	availabilityWindow = "1d"
+)
+
+// Seeder is an authoritative DNS server answering A/AAAA queries for its zone
+// with a sample of the nodes the crawler recently reached.
+type Seeder struct {
+	cmn.BaseService
+
+	config   *cfg.P2PConfig
+	zone     string
+	sw       *p2p.Switch
+	addrBook *p2p.AddrBook
+	store    *stats.Store
+	policy   p2p.VersionPolicy
+
+	udp *dns.Server
+	tcp *dns.Server
+
+	mtx        sync.Mutex
+	rand       *rand.Rand
+	candidates []*p2p.NetAddress
+}
+
+// NewSeeder creates a DNS seeder for the configured zone
+func NewSeeder(config *cfg.P2PConfig, sw *p2p.Switch, addrBook *p2p.AddrBook, store *stats.Store, policy p2p.VersionPolicy) *Seeder {
+	s := &Seeder{
+		config:   config,
+		zone:     dns.Fqdn(strings.ToLower(config.DNSSeedZone)),
+		sw:       sw,
+		addrBook: addrBook,
+		store:    store,
+		policy:   policy,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	s.BaseService = *cmn.NewBaseService(nil, "DNS Seeder", s)
+	return s
+}
+
+// OnStart implements BaseService
+func (s *Seeder) OnStart() error {
+	s.refresh()
+
+	packetConn, err := net.ListenPacket("udp", s.config.DNSSeedAddress)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", s.config.DNSSeedAddress)
+	if err != nil {
+		packetConn.Close()
+		return err
+	}
+
+	handler := dns.HandlerFunc(s.serveDNS)
+	s.udp = &dns.Server{PacketConn: packetConn, Handler: handler}
+	s.tcp = &dns.Server{Listener: listener, Handler: handler}
+	for _, server := range []*dns.Server{s.udp, s.tcp} {
+		go func(server *dns.Server) {
+			if err := server.ActivateAndServe(); err != nil {
+				log.WithField("err", err).Error("DNS seeder stopped")
+			}
+		}(server)
+	}
+
+	log.WithFields(log.Fields{"laddr": s.config.DNSSeedAddress, "zone": s.zone}).Info("DNS seeder listening")
+	go s.refreshRoutine()
+	return nil
+}
+
+// OnStop implements BaseService
+func (s *Seeder) OnStop() {
+	for _, server := range []*dns.Server{s.udp, s.tcp} {
+		if err := server.Shutdown(); err != nil {
+			log.WithField("err", err).Warn("DNS seeder shutdown")
+		}
+	}
+}
+
+func (s *Seeder) refreshRoutine() {
+	ticker := time.NewTicker(refreshPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.refresh()
+		case <-s.Quit:
+			return
+		}
+	}
+}
+
+// refresh rebuilds the candidate list: old bucket addresses on our listen
+// port whose latest dial succeeded recently, available enough over the last
+// day and whose node is on our network with a compatible version.
+func (s *Seeder) refresh() {
+	nodes, err := s.store.ListNodes()
+	if err != nil {
+		log.WithField("err", err).Error("DNS seeder fail to list nodes")
+		return
+	}
+	infos := infosByAddr(nodes)
+
+	ours := s.sw.NodeInfo()
+	port := uint16(ours.ListenPort())
+	maxAge := time.Duration(s.config.DNSSeedMaxAge) * time.Second
+	now := time.Now()
+
+	candidates := []*p2p.NetAddress{}
+	for _, entry := range s.addrBook.Entries() {
+		if !entry.Old || entry.Addr.Port != port || !entry.Addr.Routable() {
+			continue
+		}
+		record, err := s.store.GetAddr(entry.Addr.String())
+		if err != nil || !record.Reachable() || now.Sub(record.LastSuccess) > maxAge {
+			continue
+		}
+		if record.Availability.Score(availabilityWindow) < s.config.DNSSeedMinAvailability {
+			continue
+		}
+		info, ok := infos[entry.Addr.String()]
+		if !ok {
+			continue
+		}
+		if err := ours.CompatibleWith(&p2p.NodeInfo{Network: info.Network, Version: info.Version}, s.policy); err != nil {
+			continue
+		}
+		candidates = append(candidates, entry.Addr)
+	}
+
+	s.mtx.Lock()
+	s.candidates = candidates
+	s.mtx.Unlock()
+	log.WithField("candidates", len(candidates)).Debug("DNS seeder refreshed")
+}
+
+// infosByAddr indexes the latest NodeInfo of every node by the addresses it
+// can be dialed at, its announced listen address and its IPs on the listen port.
+func infosByAddr(nodes []*stats.NodeRecord) map[string]*stats.InfoRevision {
+	infos := make(map[string]*stats.InfoRevision)
+	for _, node := range nodes {
+		info := node.LatestInfo()
+		if info == nil {
+			continue
+		}
+		infos[info.ListenAddr] = info
+
+		_, port, err := net.SplitHostPort(info.ListenAddr)
+		if err != nil {
+			continue
+		}
+		for _, ip := range node.IPs {
+			infos[net.JoinHostPort(ip.IP, port)] = info
+		}
+	}
+	return infos
+}
+
+// pick returns up to DNSSeedMaxAnswers random candidates of the IP family,
+// at most one per network group.
+func (s *Seeder) pick(ipv6 bool) []net.IP {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	groups := make(map[string]struct{})
+	ips := []net.IP{}
+	for _, i := range s.rand.Perm(len(s.candidates)) {
+		if len(ips) >= s.config.DNSSeedMaxAnswers {
+			break
+		}
+		addr := s.candidates[i]
+		if (addr.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		group := s.addrBook.GroupKey(addr)
+		if _, ok := groups[group]; ok {
+			continue
+		}
+		groups[group] = struct{}{}
+		ips = append(ips, addr.IP)
+	}
+	return ips
+}
+
+func (s *Seeder) serveDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	if len(req.Question) != 1 {
+		resp.SetRcode(req, dns.RcodeFormatError)
+		s.writeMsg(w, resp)
+		return
+	}
+	q := req.Question[0]
+	name := strings.ToLower(q.Name)
+	if !dns.IsSubDomain(s.zone, name) {
+		resp.SetRcode(req, dns.RcodeRefused)
+		resp.Authoritative = false
+		s.writeMsg(w, resp)
+		return
+	}
+	if name != s.zone {
+		resp.SetRcode(req, dns.RcodeNameError)
+		resp.Ns = []dns.RR{s.soa()}
+		s.writeMsg(w, resp)
+		return
+	}
+
+	switch q.Qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		for _, ip := range s.pick(q.Qtype == dns.TypeAAAA) {
+			resp.Answer = append(resp.Answer, s.addrRecord(q.Name, ip))
+		}
+	case dns.TypeNS:
+		if s.config.DNSSeedNS != "" {
+			resp.Answer = append(resp.Answer, &dns.NS{Hdr: s.header(q.Name, dns.TypeNS), Ns: dns.Fqdn(s.config.DNSSeedNS)})
+		}
+	case dns.TypeSOA:
+		resp.Answer = append(resp.Answer, s.soa())
+	}
+	if len(resp.Answer) == 0 {
+		resp.Ns = []dns.RR{s.soa()}
+	}
+	s.writeMsg(w, resp)
+}
+
+func (s *Seeder) writeMsg(w dns.ResponseWriter, msg *dns.Msg) {
+	if err := w.WriteMsg(msg); err != nil {
+		log.WithFields(log.Fields{"remote": w.RemoteAddr(), "err": err}).Debug("DNS seeder fail to write response")
+	}
+}
+
+func (s *Seeder) header(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: uint32(s.config.DNSSeedTTL)}
+}
+
+func (s *Seeder) addrRecord(name string, ip net.IP) dns.RR {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &dns.A{Hdr: s.header(name, dns.TypeA), A: ip4}
+	}
+	return &dns.AAAA{Hdr: s.header(name, dns.TypeAAAA), AAAA: ip}
+}
+
+func (s *Seeder) soa() dns.RR {
+	ns := s.config.DNSSeedNS
+	if ns == "" {
+		ns = s.zone
+	}
+	return &dns.SOA{
+		Hdr:     s.header(s.zone, dns.TypeSOA),
+		Ns:      dns.Fqdn(ns),
+		Mbox:    dns.Fqdn("hostmaster." + s.zone),
+		Serial:  uint32(time.Now().Unix()),
+		Refresh: 604800,
+		Retry:   86400,
+		Expire:  2592000,
+		Minttl:  uint32(s.config.DNSSeedTTL),
+	}
+}
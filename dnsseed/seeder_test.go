@@ -0,0 +1,117 @@
+package dnsseed
+
+import (
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	cfg "github.com/nodestats/config"
+	"github.com/nodestats/p2p"
+)
+
+const testZone = "seed.example.com"
+
+// testSeeder serves the candidates on 127.0.0.1 over UDP, it returns the
+// server address and a function shutting the server down.
+func testSeeder(t *testing.T, candidates []*p2p.NetAddress) (string, func()) {
+	config := cfg.DefaultP2PConfig()
+	config.DNSSeedZone = testZone
+	config.DNSSeedNS = "ns." + testZone
+	config.DNSSeedMaxAnswers = 10
+
+	s := NewSeeder(config, nil, p2p.NewAddrBook("", false, 0), nil, nil)
+	s.candidates = candidates
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: packetConn, Handler: dns.HandlerFunc(s.serveDNS), NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	return packetConn.LocalAddr().String(), func() { server.Shutdown() }
+}
+
+func query(t *testing.T, addr, name string, qtype uint16) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), qtype)
+	client := &dns.Client{Timeout: 2 * time.Second}
+	resp, _, err := client.Exchange(req, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func answerIPs(resp *dns.Msg) []string {
+	ips := []string{}
+	for _, rr := range resp.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			ips = append(ips, rr.A.String())
+		case *dns.AAAA:
+			ips = append(ips, rr.AAAA.String())
+		}
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+func TestSeederAnswers(t *testing.T) {
+	candidates := []*p2p.NetAddress{
+		p2p.NewNetAddressIPPort(net.ParseIP("1.1.0.1"), 46656),
+		p2p.NewNetAddressIPPort(net.ParseIP("1.1.0.2"), 46656), // same /16 as 1.1.0.1
+		p2p.NewNetAddressIPPort(net.ParseIP("2.2.0.1"), 46656),
+		p2p.NewNetAddressIPPort(net.ParseIP("2001:db8::1"), 46656),
+	}
+	addr, shutdown := testSeeder(t, candidates)
+	defer shutdown()
+
+	resp := query(t, addr, testZone, dns.TypeA)
+	if resp.Rcode != dns.RcodeSuccess || !resp.Authoritative {
+		t.Fatalf("unexpected rcode %d, authoritative %v", resp.Rcode, resp.Authoritative)
+	}
+	ips := answerIPs(resp)
+	if len(ips) != 2 || ips[1] != "2.2.0.1" || (ips[0] != "1.1.0.1" && ips[0] != "1.1.0.2") {
+		t.Errorf("expected one A record per group, got %v", ips)
+	}
+
+	resp = query(t, addr, "SEED.example.com", dns.TypeAAAA)
+	if ips := answerIPs(resp); len(ips) != 1 || ips[0] != "2001:db8::1" {
+		t.Errorf("expected the AAAA record, got %v", ips)
+	}
+	for _, rr := range resp.Answer {
+		if rr.Header().Ttl != uint32(cfg.DefaultP2PConfig().DNSSeedTTL) {
+			t.Errorf("unexpected ttl %d", rr.Header().Ttl)
+		}
+	}
+}
+
+func TestSeederZone(t *testing.T) {
+	addr, shutdown := testSeeder(t, []*p2p.NetAddress{p2p.NewNetAddressIPPort(net.ParseIP("1.1.0.1"), 46656)})
+	defer shutdown()
+
+	resp := query(t, addr, "example.org", dns.TypeA)
+	if resp.Rcode != dns.RcodeRefused || resp.Authoritative || len(resp.Answer) != 0 {
+		t.Errorf("expected a refused answer outside the zone, got rcode %d with %d answers", resp.Rcode, len(resp.Answer))
+	}
+
+	resp = query(t, addr, "www."+testZone, dns.TypeA)
+	if resp.Rcode != dns.RcodeNameError || len(resp.Answer) != 0 || len(resp.Ns) != 1 {
+		t.Errorf("expected NXDOMAIN with the SOA in a subdomain, got rcode %d with %d answers", resp.Rcode, len(resp.Answer))
+	}
+
+	resp = query(t, addr, testZone, dns.TypeNS)
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.NS).Ns != "ns."+testZone+"." {
+		t.Errorf("unexpected NS answer %v", resp.Answer)
+	}
+
+	resp = query(t, addr, testZone, dns.TypeMX)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 || len(resp.Ns) != 1 {
+		t.Errorf("expected an empty answer with the SOA, got rcode %d with %d answers", resp.Rcode, len(resp.Answer))
+	}
+}
@@ -0,0 +1,101 @@
+// Package geoip maps IP addresses to their country, city and autonomous
+// system with MaxMind format (MMDB) databases, GeoLite2 City and ASN or
+// compatible files.
+package geoip
+
+import (
+	"net"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+)
+
+// ErrNoDatabase is returned by Open when no database path is given
+var ErrNoDatabase = errors.New("no geoip database configured")
+
+// Location is what the databases know about an IP, fields are empty when the
+// IP isn't found or the matching database isn't configured.
+type Location struct {
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	City    string `json:"city,omitempty"`    // English name
+	ASN     uint   `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"` // organisation owning the autonomous system
+}
+
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+type asnRecord struct {
+	ASN uint   `maxminddb:"autonomous_system_number"`
+	Org string `maxminddb:"autonomous_system_organization"`
+}
+
+// Resolver looks IPs up in the city and ASN databases
+type Resolver struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// Open opens the databases, either path may be empty but not both
+func Open(cityPath, asnPath string) (*Resolver, error) {
+	if cityPath == "" && asnPath == "" {
+		return nil, ErrNoDatabase
+	}
+
+	r := &Resolver{}
+	var err error
+	if cityPath != "" {
+		if r.city, err = maxminddb.Open(cityPath); err != nil {
+			return nil, errors.Wrap(err, "open geoip city database")
+		}
+	}
+	if asnPath != "" {
+		if r.asn, err = maxminddb.Open(asnPath); err != nil {
+			r.Close()
+			return nil, errors.Wrap(err, "open geoip asn database")
+		}
+	}
+	return r, nil
+}
+
+// Lookup returns the location of ip, a zero Location when no database knows it
+func (r *Resolver) Lookup(ip net.IP) (*Location, error) {
+	loc := &Location{}
+	if r.city != nil {
+		record := &cityRecord{}
+		if err := r.city.Lookup(ip, record); err != nil {
+			return nil, errors.Wrapf(err, "geoip city lookup %v", ip)
+		}
+		loc.Country = record.Country.ISOCode
+		loc.City = record.City.Names["en"]
+	}
+	if r.asn != nil {
+		record := &asnRecord{}
+		if err := r.asn.Lookup(ip, record); err != nil {
+			return nil, errors.Wrapf(err, "geoip asn lookup %v", ip)
+		}
+		loc.ASN = record.ASN
+		loc.Org = record.Org
+	}
+	return loc, nil
+}
+
+// Close closes the databases
+func (r *Resolver) Close() error {
+	var err error
+	if r.city != nil {
+		err = r.city.Close()
+	}
+	if r.asn != nil {
+		if asnErr := r.asn.Close(); err == nil {
+			err = asnErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package geoip
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nodestats/geoip/geoiptest"
+)
+
+func testDatabases(t *testing.T) (cityPath, asnPath string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cityPath = filepath.Join(dir, "city.mmdb")
+	asnPath = filepath.Join(dir, "asn.mmdb")
+
+	err = geoiptest.WriteDB(cityPath, "GeoLite2-City", map[string]map[string]interface{}{
+		"5.9.0.0/16":     geoiptest.CityRecord("DE", "Falkenstein"),
+		"52.95.0.0/16":   geoiptest.CityRecord("US", "Ashburn"),
+		"203.0.113.0/24": geoiptest.CityRecord("AU", ""),
+	})
+	if err == nil {
+		err = geoiptest.WriteDB(asnPath, "GeoLite2-ASN", map[string]map[string]interface{}{
+			"5.9.0.0/16":   geoiptest.ASNRecord(24940, "Hetzner Online GmbH"),
+			"52.95.0.0/16": geoiptest.ASNRecord(16509, "AMAZON-02"),
+		})
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return cityPath, asnPath, func() { os.RemoveAll(dir) }
+}
+
+func TestLookup(t *testing.T) {
+	cityPath, asnPath, cleanup := testDatabases(t)
+	defer cleanup()
+
+	r, err := Open(cityPath, asnPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	cases := []struct {
+		ip       string
+		expected Location
+	}{
+		{"5.9.10.11", Location{Country: "DE", City: "Falkenstein", ASN: 24940, Org: "Hetzner Online GmbH"}},
+		{"52.95.1.2", Location{Country: "US", City: "Ashburn", ASN: 16509, Org: "AMAZON-02"}},
+		// city database only
+		{"203.0.113.5", Location{Country: "AU"}},
+		// in neither database
+		{"192.0.2.1", Location{}},
+	}
+	for _, c := range cases {
+		loc, err := r.Lookup(net.ParseIP(c.ip))
+		if err != nil {
+			t.Errorf("%s: %v", c.ip, err)
+			continue
+		}
+		if *loc != c.expected {
+			t.Errorf("%s: expected %+v, got %+v", c.ip, c.expected, *loc)
+		}
+	}
+}
+
+func TestOpenSingleDatabase(t *testing.T) {
+	cityPath, asnPath, cleanup := testDatabases(t)
+	defer cleanup()
+
+	r, err := Open("", asnPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	loc, err := r.Lookup(net.ParseIP("5.9.10.11"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Location{ASN: 24940, Org: "Hetzner Online GmbH"}); *loc != expected {
+		t.Errorf("expected %+v, got %+v", expected, *loc)
+	}
+
+	if _, err := Open("", ""); err != ErrNoDatabase {
+		t.Errorf("expected ErrNoDatabase, got %v", err)
+	}
+	if _, err := Open(cityPath, filepath.Join(filepath.Dir(asnPath), "missing.mmdb")); err == nil {
+		t.Error("expected an error for a missing database")
+	}
+}
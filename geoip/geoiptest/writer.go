@@ -0,0 +1,223 @@
+// Package geoiptest writes small MaxMind format (MMDB) databases for tests.
+package geoiptest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"time"
+)
+
+const recordSize = 24
+
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// CityRecord is the entry of a GeoLite2 City database locating an IP
+func CityRecord(country, city string) map[string]interface{} {
+	return map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": country},
+		"city":    map[string]interface{}{"names": map[string]interface{}{"en": city}},
+	}
+}
+
+// ASNRecord is the entry of a GeoLite2 ASN database
+func ASNRecord(asn uint32, org string) map[string]interface{} {
+	return map[string]interface{}{
+		"autonomous_system_number":       asn,
+		"autonomous_system_organization": org,
+	}
+}
+
+// WriteDB writes an IPv4 database of type dbType to path, the records are
+// keyed by CIDR network and the networks must not overlap. Records hold maps
+// with string keys, strings, []string and unsigned integers.
+func WriteDB(path, dbType string, records map[string]map[string]interface{}) error {
+	tree := &treeNode{}
+	data := &bytes.Buffer{}
+
+	networks := make([]string, 0, len(records))
+	for network := range records {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return err
+		}
+		ip := ipNet.IP.To4()
+		ones, _ := ipNet.Mask.Size()
+		if ip == nil || ones == 0 {
+			return fmt.Errorf("unsupported network %s", network)
+		}
+
+		offset := data.Len()
+		if err := encode(data, records[network]); err != nil {
+			return err
+		}
+		tree.insert(ip, ones, offset)
+	}
+
+	nodes := tree.number()
+	out := &bytes.Buffer{}
+	for _, n := range nodes {
+		for _, r := range n.records {
+			value := uint32(len(nodes)) // no data
+			if r.child != nil {
+				value = uint32(r.child.index)
+			} else if r.hasData {
+				value = uint32(len(nodes) + 16 + r.offset)
+			}
+			out.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.Write(metadataMarker)
+
+	metadata := map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               dbType,
+		"description":                 map[string]interface{}{"en": "nodestats test database"},
+		"ip_version":                  uint16(4),
+		"languages":                   []string{"en"},
+		"node_count":                  uint32(len(nodes)),
+		"record_size":                 uint16(recordSize),
+	}
+	if err := encode(out, metadata); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out.Bytes(), 0644)
+}
+
+// treeNode is a node of the binary search tree, one record per bit value
+type treeNode struct {
+	index   int
+	records [2]struct {
+		child   *treeNode
+		hasData bool
+		offset  int // in the data section
+	}
+}
+
+func (n *treeNode) insert(ip net.IP, ones, offset int) {
+	for i := 0; i < ones; i++ {
+		bit := (ip[i/8] >> uint(7-i%8)) & 1
+		r := &n.records[bit]
+		if i == ones-1 {
+			r.hasData, r.offset = true, offset
+			return
+		}
+		if r.child == nil {
+			r.child = &treeNode{}
+		}
+		n = r.child
+	}
+}
+
+// number indexes the nodes depth first from the root and returns them in order
+func (n *treeNode) number() []*treeNode {
+	nodes := []*treeNode{}
+	var walk func(*treeNode)
+	walk = func(n *treeNode) {
+		n.index = len(nodes)
+		nodes = append(nodes, n)
+		for _, r := range n.records {
+			if r.child != nil {
+				walk(r.child)
+			}
+		}
+	}
+	walk(n)
+	return nodes
+}
+
+// MMDB data section types
+const (
+	typeString = 2
+	typeUint16 = 5
+	typeUint32 = 6
+	typeMap    = 7
+	typeUint64 = 9
+	typeArray  = 11
+)
+
+func encode(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		writeControl(buf, typeString, len(v))
+		buf.WriteString(v)
+	case uint16:
+		writeUint(buf, typeUint16, uint64(v))
+	case uint32:
+		writeUint(buf, typeUint32, uint64(v))
+	case uint:
+		writeUint(buf, typeUint32, uint64(v))
+	case uint64:
+		writeUint(buf, typeUint64, v)
+	case []string:
+		writeControl(buf, typeArray, len(v))
+		for _, s := range v {
+			encode(buf, s)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeControl(buf, typeMap, len(v))
+		for _, k := range keys {
+			encode(buf, k)
+			if err := encode(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported MMDB value %T", v)
+	}
+	return nil
+}
+
+// writeUint writes the big endian value without its leading zero bytes
+func writeUint(buf *bytes.Buffer, dataType int, v uint64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	b = bytes.TrimLeft(b, "\x00")
+	writeControl(buf, dataType, len(b))
+	buf.Write(b)
+}
+
+// writeControl writes the control byte of a field, followed by the extended
+// type and the size bytes when they don't fit in it.
+func writeControl(buf *bytes.Buffer, dataType, size int) {
+	ctrl := byte(0)
+	if dataType <= 7 {
+		ctrl = byte(dataType << 5)
+	}
+	var sizeBytes []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 29+256:
+		ctrl |= 29
+		sizeBytes = []byte{byte(size - 29)}
+	case size < 285+65536:
+		ctrl |= 30
+		sizeBytes = []byte{byte((size - 285) >> 8), byte(size - 285)}
+	default:
+		ctrl |= 31
+		size -= 65821
+		sizeBytes = []byte{byte(size >> 16), byte(size >> 8), byte(size)}
+	}
+	buf.WriteByte(ctrl)
+	if dataType > 7 {
+		buf.WriteByte(byte(dataType - 7))
+	}
+	buf.Write(sizeBytes)
+}
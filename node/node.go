@@ -1,26 +1,204 @@
 package node
 
 import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	cmn "github.com/tendermint/tmlibs/common"
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/nodestats/api"
 	cfg "github.com/nodestats/config"
+	"github.com/nodestats/dnsseed"
+	"github.com/nodestats/geoip"
 	"github.com/nodestats/p2p"
+	"github.com/nodestats/p2p/reactor"
+	"github.com/nodestats/stats"
 )
 
 type Node struct {
-	Config *cfg.Config
-	sw *p2p.Switch
+	cmn.BaseService
+
+	Config   *cfg.P2PConfig
+	sw       *p2p.Switch
+	addrBook *p2p.AddrBook
+	statsDB  dbm.DB
+	store    *stats.Store
+	recorder *stats.Recorder
+	dnsSeed  *dnsseed.Seeder
+	geoIP    *geoip.Resolver
+	api      *api.Server
+
+	collector prometheus.Collector
 }
 
-func NewNode(config *cfg.Config) *Node {
-	addrBook := p2p.NewAddrBook(cfg.DefaultConfig().AddrBook)
+// NewNode creates the node services, nothing is left open when it fails.
+func NewNode(config *cfg.P2PConfig) (n *Node, err error) {
+	if err := cmn.EnsureDir(config.RootDir, 0700); err != nil {
+		return nil, err
+	}
+
+	privKey, err := loadOrGenNodeKey(config.NodeKeyFile())
+	if err != nil {
+		return nil, err
+	}
+
+	versionPolicy, err := p2p.NewVersionPolicy(config.VersionPolicy, config.VersionAllowList)
+	if err != nil {
+		return nil, err
+	}
+
+	statsDB := dbm.NewDB("nodestats", config.DBBackend, config.DBDir())
+	store := stats.NewStore(statsDB)
+	var l p2p.Listener
+	var resolver *geoip.Resolver
+	defer func() {
+		if err == nil {
+			return
+		}
+		if resolver != nil {
+			resolver.Close()
+		}
+		// stopping the listener also removes its port mapping
+		if l != nil {
+			l.Stop()
+		}
+		statsDB.Close()
+	}()
+
+	addrBook := p2p.NewAddrBook(config.AddrBookFile(), config.AddrBookStrict, time.Duration(config.AddrBookSave)*time.Second)
 	sw := p2p.NewSwitch(config, addrBook)
-	return &Node{
-		Config: config,
-		sw:     sw,
+
+	l, err = p2p.NewDefaultListener(config.ListenAddress, config.SkipUPNP)
+	if err != nil {
+		return nil, err
+	}
+	sw.AddListener(l)
+	if config.PexReactor {
+		pexReactor := reactor.NewPEXReactor(addrBook)
+		sw.AddReactor("PEX", pexReactor)
+	}
+
+	sw.SetNodeInfo(makeNodeInfo(config, l))
+	sw.SetNodePrivKey(privKey)
+	sw.SetVersionPolicy(versionPolicy)
+
+	n = &Node{
+		Config:   config,
+		sw:       sw,
+		addrBook: addrBook,
+		statsDB:  statsDB,
+		store:    store,
+		recorder: stats.NewRecorder(sw, store),
 	}
+	if config.APIAddress != "" {
+		n.api = api.NewServer(config.APIAddress, sw, addrBook, store)
+	}
+	if config.DNSSeedAddress != "" {
+		if config.DNSSeedZone == "" {
+			return nil, errors.New("dnsseed_zone is required by the DNS seeder")
+		}
+		n.dnsSeed = dnsseed.NewSeeder(config, sw, addrBook, store, versionPolicy)
+	}
+	if config.GeoIPCityDB != "" || config.GeoIPASNDB != "" {
+		if resolver, err = geoip.Open(config.GeoIPCityDBFile(), config.GeoIPASNDBFile()); err != nil {
+			return nil, err
+		}
+		store.SetGeoIP(resolver)
+		n.geoIP = resolver
+	}
+	if n.collector, err = registerCollector(p2p.NewSwitchCollector(sw)); err != nil {
+		return nil, err
+	}
+	n.BaseService = *cmn.NewBaseService(nil, "Node", n)
+	return n, nil
 }
 
-func (n *Node) Start() error {
-	n.sw.Start()
+// OnStart implements BaseService
+func (n *Node) OnStart() error {
+	if _, err := n.addrBook.Start(); err != nil {
+		return err
+	}
+	// the recorder subscribes before the switch emits its first event
+	if _, err := n.recorder.Start(); err != nil {
+		return err
+	}
+	if _, err := n.sw.Start(); err != nil {
+		return err
+	}
+	if n.api != nil {
+		if _, err := n.api.Start(); err != nil {
+			return err
+		}
+	}
+	if n.dnsSeed != nil {
+		if _, err := n.dnsSeed.Start(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// OnStop implements BaseService
+func (n *Node) OnStop() {
+	n.BaseService.OnStop()
+	if n.dnsSeed != nil {
+		n.dnsSeed.Stop()
+	}
+	if n.api != nil {
+		n.api.Stop()
+	}
+	n.sw.Stop()
+	n.recorder.Stop()
+	n.addrBook.Stop()
+	n.statsDB.Close()
+	if n.geoIP != nil {
+		n.geoIP.Close()
+	}
+	prometheus.Unregister(n.collector)
+}
+
+// RunForever blocks until SIGINT or SIGTERM is received, then stops the node.
+func (n *Node) RunForever() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	sig := <-c
+	log.WithField("signal", sig).Info("captured signal, shutting down")
+	n.Stop()
+}
+
+// registerCollector registers c with the default registry, replacing the
+// collector of a previous node of the process.
+func registerCollector(c prometheus.Collector) (prometheus.Collector, error) {
+	err := prometheus.Register(c)
+	if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		prometheus.Unregister(are.ExistingCollector)
+		err = prometheus.Register(c)
+	}
+	return c, err
+}
+
+// Switch returns the node's p2p switch
+func (n *Node) Switch() *p2p.Switch {
+	return n.sw
+}
+
+// Store returns the node's stats store
+func (n *Node) Store() *stats.Store {
+	return n.store
+}
+
+func makeNodeInfo(config *cfg.P2PConfig, l p2p.Listener) *p2p.NodeInfo {
+	return &p2p.NodeInfo{
+		Moniker:    config.Moniker,
+		Network:    config.Network,
+		Version:    config.Version,
+		ListenAddr: l.ExternalAddress().String(),
+		Other:      []string{},
+	}
+}
@@ -0,0 +1,41 @@
+package node
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	crypto "github.com/tendermint/go-crypto"
+	cmn "github.com/tendermint/tmlibs/common"
+)
+
+// loadOrGenNodeKey reads the hex encoded node private key from filePath,
+// generating and saving a new one when the file does not exist yet.
+func loadOrGenNodeKey(filePath string) (crypto.PrivKeyEd25519, error) {
+	var privKey crypto.PrivKeyEd25519
+	if !cmn.FileExists(filePath) {
+		privKey = crypto.GenPrivKeyEd25519()
+		if err := cmn.WriteFileAtomic(filePath, []byte(hex.EncodeToString(privKey[:])), 0600); err != nil {
+			return privKey, errors.Wrap(err, "save node key")
+		}
+		log.WithField("file", filePath).Info("generated new node key")
+		return privKey, nil
+	}
+
+	data, err := cmn.ReadFile(filePath)
+	if err != nil {
+		return privKey, errors.Wrap(err, "read node key")
+	}
+
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return privKey, errors.Wrap(err, "decode node key")
+	}
+	if len(raw) != len(privKey) {
+		return privKey, errors.Errorf("node key has wrong length %d, expected %d", len(raw), len(privKey))
+	}
+
+	copy(privKey[:], raw)
+	return privKey, nil
+}
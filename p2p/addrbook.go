@@ -13,6 +13,9 @@ import (
 	"math/rand"
 	"encoding/binary"
 	"net"
+	"time"
+
+	cmn "github.com/tendermint/tmlibs/common"
 )
 
 const (
@@ -22,18 +25,33 @@ const (
 
 	newBucketCount     = 256
 	newBucketSize      = 64
+
+	oldBucketsPerGroup = 4
+	oldBucketCount     = 64
+	oldBucketSize      = 64
+
+	// GetSelection returns getSelectionPercent of the book, bounded by
+	// minGetSelection and maxGetSelection
+	getSelectionPercent = 23
+	minGetSelection     = 32
+	maxGetSelection     = 250
 )
 
 
 type AddrBook struct {
+	cmn.BaseService
+
 	key      string
 	filePath string
 	routabilityStrict bool
+	saveInterval time.Duration
 
+	saveMtx sync.Mutex // only one save to disk at a time
 	mtx sync.RWMutex
 	rand       *rand.Rand
 	ourAddrs   map[string]*NetAddress
 	addrLookup map[string]*knownAddress // new & old
+	bans       map[string]*Ban          // keyed by IP or node pubkey
 
 	bucketsNew []map[string]*knownAddress
 	bucketsOld []map[string]*knownAddress
@@ -42,15 +60,69 @@ type AddrBook struct {
 	nNew       int
 }
 
-func NewAddrBook(filePath string) *AddrBook {
+func NewAddrBook(filePath string, routabilityStrict bool, saveInterval time.Duration) *AddrBook {
 	a := &AddrBook{
-		key:      tcrypto.CRandHex(24),
-		filePath: filePath,
-		ourAddrs: make(map[string]*NetAddress),
+		key:               tcrypto.CRandHex(24),
+		filePath:          filePath,
+		routabilityStrict: routabilityStrict,
+		saveInterval:      saveInterval,
+		rand:              rand.New(rand.NewSource(time.Now().UnixNano())),
+		ourAddrs:          make(map[string]*NetAddress),
+		addrLookup:        make(map[string]*knownAddress),
+		bans:              make(map[string]*Ban),
 	}
+	a.initBuckets()
+	a.BaseService = *cmn.NewBaseService(nil, "AddrBook", a)
 	return a
 }
 
+// OnStart implements Service by loading the book and starting the periodic save.
+func (a *AddrBook) OnStart() error {
+	a.BaseService.OnStart()
+	if err := a.loadFromFile(); err != nil {
+		log.WithFields(log.Fields{"file": a.filePath, "err": err}).Error("fail to load address book, starting fresh")
+	}
+	if a.saveInterval > 0 {
+		go a.saveRoutine()
+	}
+	return nil
+}
+
+// OnStop implements Service by saving the book one last time.
+func (a *AddrBook) OnStop() {
+	a.BaseService.OnStop()
+	if err := a.SaveToFile(); err != nil {
+		log.WithField("err", err).Error("fail to save address book on stop")
+	}
+}
+
+func (a *AddrBook) saveRoutine() {
+	ticker := time.NewTicker(a.saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.purgeExpiredBans()
+			if err := a.SaveToFile(); err != nil {
+				log.WithField("err", err).Error("fail to save address book")
+			}
+		case <-a.Quit:
+			return
+		}
+	}
+}
+
+func (a *AddrBook) initBuckets() {
+	a.bucketsNew = make([]map[string]*knownAddress, newBucketCount)
+	for i := range a.bucketsNew {
+		a.bucketsNew[i] = make(map[string]*knownAddress)
+	}
+	a.bucketsOld = make([]map[string]*knownAddress, oldBucketCount)
+	for i := range a.bucketsOld {
+		a.bucketsOld[i] = make(map[string]*knownAddress)
+	}
+}
+
 // PickAddress picks a random address from random bucket
 func (a *AddrBook) PickAddress(bias int) *NetAddress {
 	a.mtx.RLock()
@@ -93,6 +165,113 @@ func (a *AddrBook) PickAddress(bias int) *NetAddress {
 	return nil
 }
 
+// GetAddressList returns a snapshot of every address in the book
+func (a *AddrBook) GetAddressList() []*NetAddress {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	addrs := make([]*NetAddress, 0, len(a.addrLookup))
+	for _, ka := range a.addrLookup {
+		addrs = append(addrs, ka.Addr)
+	}
+	return addrs
+}
+
+// GetSelection returns a random sample of the book, suitable for answering
+// a peer exchange request
+func (a *AddrBook) GetSelection() []*NetAddress {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.size() == 0 {
+		return nil
+	}
+
+	addrs := make([]*NetAddress, 0, len(a.addrLookup))
+	for _, ka := range a.addrLookup {
+		addrs = append(addrs, ka.Addr)
+	}
+
+	numAddrs := len(addrs) * getSelectionPercent / 100
+	if numAddrs < minGetSelection {
+		numAddrs = cmn.MinInt(minGetSelection, len(addrs))
+	}
+	if numAddrs > maxGetSelection {
+		numAddrs = maxGetSelection
+	}
+
+	// partial Fisher-Yates shuffle, only the first numAddrs are picked
+	for i := 0; i < numAddrs; i++ {
+		j := i + a.rand.Intn(len(addrs)-i)
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	}
+	return addrs[:numAddrs]
+}
+
+// AddrBookStatus describes the occupancy of the address book buckets
+type AddrBookStatus struct {
+	NumNew     int   `json:"num_new"`
+	NumOld     int   `json:"num_old"`
+	NewBuckets []int `json:"new_buckets"`
+	OldBuckets []int `json:"old_buckets"`
+}
+
+// AddrBookEntry is an exported view of a known address
+type AddrBookEntry struct {
+	Addr        *NetAddress `json:"addr"`
+	Src         *NetAddress `json:"src"`
+	Attempts    int32       `json:"attempts"`
+	LastAttempt time.Time   `json:"last_attempt"`
+	LastSuccess time.Time   `json:"last_success"`
+	Old         bool        `json:"old"`
+	Buckets     []int       `json:"buckets"`
+}
+
+// Status returns the number of entries in every bucket
+func (a *AddrBook) Status() *AddrBookStatus {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	status := &AddrBookStatus{
+		NumNew:     a.nNew,
+		NumOld:     a.nOld,
+		NewBuckets: make([]int, len(a.bucketsNew)),
+		OldBuckets: make([]int, len(a.bucketsOld)),
+	}
+	for i, bucket := range a.bucketsNew {
+		status.NewBuckets[i] = len(bucket)
+	}
+	for i, bucket := range a.bucketsOld {
+		status.OldBuckets[i] = len(bucket)
+	}
+	return status
+}
+
+// Entries returns a snapshot of every known address
+func (a *AddrBook) Entries() []*AddrBookEntry {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	entries := make([]*AddrBookEntry, 0, len(a.addrLookup))
+	for _, ka := range a.addrLookup {
+		entries = append(entries, &AddrBookEntry{
+			Addr:        ka.Addr,
+			Src:         ka.Src,
+			Attempts:    ka.Attempts,
+			LastAttempt: ka.LastAttempt,
+			LastSuccess: ka.LastSuccess,
+			Old:         ka.isOld(),
+			Buckets:     append([]int{}, ka.Buckets...),
+		})
+	}
+	return entries
+}
+
+// GroupKey returns the network group of the address, /16 for IPv4 and /32 for IPv6
+func (a *AddrBook) GroupKey(na *NetAddress) string {
+	return a.groupKey(na)
+}
+
 // Size count the number of know address
 func (a *AddrBook) Size() int {
 	a.mtx.RLock()
@@ -100,6 +279,13 @@ func (a *AddrBook) Size() int {
 	return a.size()
 }
 
+// Sizes returns the number of addresses in the new and old buckets
+func (a *AddrBook) Sizes() (nNew, nOld int) {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	return a.nNew, a.nOld
+}
+
 func (a *AddrBook) size() int {
 	return a.nNew + a.nOld
 }
@@ -111,6 +297,16 @@ func (a *AddrBook) AddAddress(addr, src *NetAddress) error {
 	return a.addAddress(addr, src)
 }
 
+// AddOurAddress records one of our own addresses so it never enters the book.
+func (a *AddrBook) AddOurAddress(addr *NetAddress) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	log.WithField("address", addr).Info("add our address to address book")
+	a.ourAddrs[addr.String()] = addr
+	a.removeAddress(addr)
+}
+
 // MarkGood marks the peer as good and moves it into an "old" bucket.
 func (a *AddrBook) MarkGood(addr *NetAddress) {
 	a.mtx.Lock()
@@ -122,11 +318,11 @@ func (a *AddrBook) MarkGood(addr *NetAddress) {
 	}
 
 	ka.markGood()
-	//if ka.isNew() {
-	//	if err := a.moveToOld(ka); err != nil {
-	//		log.WithField("err", err).Error("fail on move to old bucket")
-	//	}
-	//}
+	if ka.isNew() {
+		if err := a.moveToOld(ka); err != nil {
+			log.WithField("err", err).Error("fail on move to old bucket")
+		}
+	}
 }
 
 // MarkAttempt marks that an attempt was made to connect to the address.
@@ -180,6 +376,72 @@ func (a *AddrBook) addToNewBucket(ka *knownAddress, bucketIdx int) error {
 	return nil
 }
 
+func (a *AddrBook) addToOldBucket(ka *knownAddress, bucketIdx int) bool {
+	if ka.isNew() {
+		log.WithField("address", ka.Addr).Error("cant add new address to old bucket")
+		return false
+	}
+	if len(ka.Buckets) != 0 {
+		log.WithField("address", ka.Addr).Error("cant add already old address to another old bucket")
+		return false
+	}
+
+	addrStr := ka.Addr.String()
+	bucket := a.getBucket(bucketTypeOld, bucketIdx)
+	if _, ok := bucket[addrStr]; ok {
+		return true
+	}
+	if len(bucket) >= oldBucketSize {
+		return false
+	}
+
+	bucket[addrStr] = ka
+	a.addrLookup[addrStr] = ka
+	if ka.addBucketRef(bucketIdx) == 1 {
+		a.nOld++
+	}
+	return true
+}
+
+// moveToOld promotes a new address into the old bucket picked by its group,
+// when that bucket is full its oldest entry is demoted back to a new bucket.
+func (a *AddrBook) moveToOld(ka *knownAddress) error {
+	if ka.isOld() {
+		return nil
+	}
+	if len(ka.Buckets) == 0 {
+		return errors.New("cant move an address without bucket to old")
+	}
+
+	freedBucket := ka.Buckets[0]
+	a.removeFromAllBuckets(ka)
+	ka.BucketType = bucketTypeOld
+
+	oldBucketIdx := a.calcOldBucket(ka.Addr)
+	if a.addToOldBucket(ka, oldBucketIdx) {
+		return nil
+	}
+
+	// No room, demote the oldest old entry to the new bucket we just freed
+	if oldest := a.pickOldest(bucketTypeOld, oldBucketIdx); oldest != nil {
+		a.removeFromBucket(oldest, oldBucketIdx)
+		oldest.BucketType = bucketTypeNew
+		if err := a.addToNewBucket(oldest, freedBucket); err != nil {
+			log.WithFields(log.Fields{"address": oldest.Addr, "err": err}).Error("fail to demote old address")
+		}
+	}
+
+	if !a.addToOldBucket(ka, oldBucketIdx) {
+		// keep the address in the new tier rather than losing it
+		ka.BucketType = bucketTypeNew
+		if err := a.addToNewBucket(ka, freedBucket); err != nil {
+			log.WithFields(log.Fields{"address": ka.Addr, "err": err}).Error("fail to restore address to new bucket")
+		}
+		return errors.New("fail to add address to old bucket after demotion")
+	}
+	return nil
+}
+
 func (a *AddrBook) addAddress(addr, src *NetAddress) error {
 	if addr == nil || src == nil {
 		return errors.New("can't add nil to address book")
@@ -187,6 +449,9 @@ func (a *AddrBook) addAddress(addr, src *NetAddress) error {
 	if _, ok := a.ourAddrs[addr.String()]; ok {
 		return errors.New("add ourselves to address book")
 	}
+	if ban, ok := a.bans[addr.IP.String()]; ok && !ban.expired(time.Now()) {
+		return errors.New("add banned address to address book")
+	}
 	//if a.routabilityStrict && !addr.Routable() {
 	//	return errors.New("cannot add non-routable address")
 	//}
@@ -229,6 +494,24 @@ func (a *AddrBook) calcNewBucket(addr, src *NetAddress) int {
 	return int(binary.BigEndian.Uint64(hash2) % newBucketCount)
 }
 
+func (a *AddrBook) calcOldBucket(addr *NetAddress) int {
+	data1 := []byte{}
+	data1 = append(data1, []byte(a.key)...)
+	data1 = append(data1, []byte(addr.String())...)
+	hash1 := crypto.DoubleSha256(data1)
+	hash64 := binary.BigEndian.Uint64(hash1)
+	hash64 %= oldBucketsPerGroup
+	var hashbuf [8]byte
+	binary.BigEndian.PutUint64(hashbuf[:], hash64)
+	data2 := []byte{}
+	data2 = append(data2, []byte(a.key)...)
+	data2 = append(data2, a.groupKey(addr)...)
+	data2 = append(data2, hashbuf[:]...)
+
+	hash2 := crypto.DoubleSha256(data2)
+	return int(binary.BigEndian.Uint64(hash2) % oldBucketCount)
+}
+
 func (a *AddrBook) groupKey(na *NetAddress) string {
 	if a.routabilityStrict && na.Local() {
 		return "local"
@@ -237,17 +520,15 @@ func (a *AddrBook) groupKey(na *NetAddress) string {
 		return "unroutable"
 	}
 	if ipv4 := na.IP.To4(); ipv4 != nil {
-		return (&net.IPNet{IP: na.IP, Mask: net.CIDRMask(16, 32)}).String()
+		return ipGroup(ipv4, 16, 32)
 	}
 	if na.RFC6145() || na.RFC6052() {
 		// last four bytes are the ip address
-		ip := net.IP(na.IP[12:16])
-		return (&net.IPNet{IP: ip, Mask: net.CIDRMask(16, 32)}).String()
+		return ipGroup(net.IP(na.IP[12:16]), 16, 32)
 	}
 	if na.RFC3964() {
-		ip := net.IP(na.IP[2:7])
-		return (&net.IPNet{IP: ip, Mask: net.CIDRMask(16, 32)}).String()
-
+		// 6to4 addresses embed the v4 address after the 2002: prefix
+		return ipGroup(net.IP(na.IP[2:6]), 16, 32)
 	}
 	if na.RFC4380() {
 		// teredo tunnels have the last 4 bytes as the v4 address XOR 0xff.
@@ -255,7 +536,7 @@ func (a *AddrBook) groupKey(na *NetAddress) string {
 		for i, byte := range na.IP[12:16] {
 			ip[i] = byte ^ 0xff
 		}
-		return (&net.IPNet{IP: ip, Mask: net.CIDRMask(16, 32)}).String()
+		return ipGroup(ip, 16, 32)
 	}
 
 	bits := 32
@@ -263,7 +544,14 @@ func (a *AddrBook) groupKey(na *NetAddress) string {
 	if heNet.Contains(na.IP) {
 		bits = 36
 	}
-	return (&net.IPNet{IP: na.IP, Mask: net.CIDRMask(bits, 128)}).String()
+	return ipGroup(na.IP, bits, 128)
+}
+
+// ipGroup returns the network of the ip with the given prefix length,
+// net.IPNet doesn't mask the ip it prints.
+func ipGroup(ip net.IP, ones, bits int) string {
+	mask := net.CIDRMask(ones, bits)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
 }
 
 func (a *AddrBook) pickOldest(bucketType byte, bucketIdx int) *knownAddress {
@@ -302,3 +590,17 @@ func (a *AddrBook) removeFromBucket(ka *knownAddress, bucketIdx int) {
 		}
 	}
 }
+
+func (a *AddrBook) removeFromAllBuckets(ka *knownAddress) {
+	addrStr := ka.Addr.String()
+	for _, bucketIdx := range ka.Buckets {
+		delete(a.getBucket(ka.BucketType, bucketIdx), addrStr)
+	}
+	ka.Buckets = nil
+	if ka.isNew() {
+		a.nNew--
+	} else {
+		a.nOld--
+	}
+	delete(a.addrLookup, addrStr)
+}
@@ -0,0 +1,248 @@
+package p2p
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testAddr(i int) *NetAddress {
+	return NewNetAddressIPPort(net.IPv4(1, byte(i>>16), byte(i>>8), byte(i)), 46656)
+}
+
+func tempBookPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "addrbook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "addrbook.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestAddrBookSaveAndLoad(t *testing.T) {
+	path, cleanup := tempBookPath(t)
+	defer cleanup()
+
+	book := NewAddrBook(path, false, 0)
+	src := testAddr(0)
+	for i := 1; i <= 10; i++ {
+		if err := book.AddAddress(testAddr(i), src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	book.MarkGood(testAddr(1))
+	book.BanIP(net.IPv4(9, 9, 9, 9), time.Hour, "test")
+	book.BanPubKey("expired", -time.Hour, "test")
+	if err := book.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewAddrBook(path, false, 0)
+	if err := loaded.loadFromFile(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.key != book.key {
+		t.Errorf("key = %q, want %q", loaded.key, book.key)
+	}
+	nNew, nOld := loaded.Sizes()
+	if nNew != 9 || nOld != 1 {
+		t.Errorf("sizes = %d new %d old, want 9 new 1 old", nNew, nOld)
+	}
+	for i := 1; i <= 10; i++ {
+		ka := loaded.addrLookup[testAddr(i).String()]
+		if ka == nil {
+			t.Fatalf("address %v not loaded", testAddr(i))
+		}
+		for _, idx := range ka.Buckets {
+			if _, ok := loaded.getBucket(ka.BucketType, idx)[ka.Addr.String()]; !ok {
+				t.Errorf("address %v missing from bucket %d", ka.Addr, idx)
+			}
+		}
+	}
+	if !loaded.addrLookup[testAddr(1).String()].isOld() {
+		t.Error("good address not restored to the old tier")
+	}
+	if _, ok := loaded.IsBanned("9.9.9.9"); !ok {
+		t.Error("active ban not restored")
+	}
+	if _, ok := loaded.bans["expired"]; ok {
+		t.Error("expired ban restored")
+	}
+}
+
+func TestAddrBookLoadMissingFile(t *testing.T) {
+	path, cleanup := tempBookPath(t)
+	defer cleanup()
+
+	book := NewAddrBook(path, false, 0)
+	if err := book.loadFromFile(); err != nil {
+		t.Fatal(err)
+	}
+	if book.Size() != 0 {
+		t.Errorf("size = %d, want 0", book.Size())
+	}
+}
+
+func TestAddrBookLoadCorruptFile(t *testing.T) {
+	path, cleanup := tempBookPath(t)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	book := NewAddrBook(path, false, 0)
+	if err := book.loadFromFile(); err != nil {
+		t.Fatal(err)
+	}
+	if book.Size() != 0 {
+		t.Errorf("size = %d, want 0", book.Size())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("corrupt file not moved aside")
+	}
+	backups, _ := filepath.Glob(path + ".*.bak")
+	if len(backups) != 1 {
+		t.Errorf("found %d backups, want 1", len(backups))
+	}
+}
+
+func TestAddrBookLoadSkipsInvalidBuckets(t *testing.T) {
+	book := NewAddrBook("", false, 0)
+	ka := newKnownAddress(testAddr(1), testAddr(0))
+	ka.Buckets = []int{-1, newBucketCount}
+	book.restoreKnownAddress(ka)
+	if book.Size() != 0 {
+		t.Errorf("size = %d, want 0", book.Size())
+	}
+
+	ka = newKnownAddress(testAddr(2), testAddr(0))
+	ka.Buckets = []int{3, newBucketCount}
+	book.restoreKnownAddress(ka)
+	if nNew, _ := book.Sizes(); nNew != 1 {
+		t.Errorf("new size = %d, want 1", nNew)
+	}
+	if len(ka.Buckets) != 1 || ka.Buckets[0] != 3 {
+		t.Errorf("buckets = %v, want [3]", ka.Buckets)
+	}
+
+	// an old address belongs to exactly one bucket
+	ka = newKnownAddress(testAddr(3), testAddr(0))
+	ka.BucketType = bucketTypeOld
+	ka.Buckets = []int{5, 6}
+	book.restoreKnownAddress(ka)
+	if _, nOld := book.Sizes(); nOld != 1 {
+		t.Errorf("old size = %d, want 1", nOld)
+	}
+	if len(ka.Buckets) != 1 || ka.Buckets[0] != 5 || len(book.bucketsOld[6]) != 0 {
+		t.Errorf("buckets = %v, want [5]", ka.Buckets)
+	}
+
+	// a new address is in at most maxNewBucketsPerAddress buckets
+	ka = newKnownAddress(testAddr(4), testAddr(0))
+	ka.Buckets = []int{10, 11, 12, 13, 14, 15}
+	book.restoreKnownAddress(ka)
+	if len(ka.Buckets) != maxNewBucketsPerAddress || len(book.bucketsNew[14]) != 0 {
+		t.Errorf("buckets = %v, want the first %d", ka.Buckets, maxNewBucketsPerAddress)
+	}
+
+	// a full bucket takes no more entries
+	for i := 0; i < newBucketSize; i++ {
+		ka = newKnownAddress(testAddr(100+i), testAddr(0))
+		ka.Buckets = []int{20}
+		book.restoreKnownAddress(ka)
+	}
+	ka = newKnownAddress(testAddr(99), testAddr(0))
+	ka.Buckets = []int{20, 21}
+	book.restoreKnownAddress(ka)
+	if len(book.bucketsNew[20]) != newBucketSize {
+		t.Errorf("bucket size = %d, want %d", len(book.bucketsNew[20]), newBucketSize)
+	}
+	if len(ka.Buckets) != 1 || ka.Buckets[0] != 21 {
+		t.Errorf("buckets = %v, want [21]", ka.Buckets)
+	}
+}
+
+func TestAddrBookMarkGoodPromotes(t *testing.T) {
+	book := NewAddrBook("", false, 0)
+	addr := testAddr(1)
+	if err := book.AddAddress(addr, testAddr(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	book.MarkGood(addr)
+	nNew, nOld := book.Sizes()
+	if nNew != 0 || nOld != 1 {
+		t.Fatalf("sizes = %d new %d old, want 0 new 1 old", nNew, nOld)
+	}
+	ka := book.addrLookup[addr.String()]
+	idx := book.calcOldBucket(addr)
+	if !ka.isOld() || len(ka.Buckets) != 1 || ka.Buckets[0] != idx {
+		t.Errorf("promoted address in %v of type %d, want old bucket %d", ka.Buckets, ka.BucketType, idx)
+	}
+	if _, ok := book.bucketsOld[idx][addr.String()]; !ok {
+		t.Error("promoted address missing from its old bucket")
+	}
+
+	// promoting again is a no-op
+	book.MarkGood(addr)
+	if nNew, nOld := book.Sizes(); nNew != 0 || nOld != 1 {
+		t.Errorf("sizes = %d new %d old after second promotion, want 0 new 1 old", nNew, nOld)
+	}
+}
+
+func TestAddrBookPromotionDemotesOldest(t *testing.T) {
+	book := NewAddrBook("", false, 0)
+	addr := testAddr(1)
+	if err := book.AddAddress(addr, testAddr(0)); err != nil {
+		t.Fatal(err)
+	}
+	freedBucket := book.addrLookup[addr.String()].Buckets[0]
+
+	// fill the old bucket the address is promoted to
+	idx := book.calcOldBucket(addr)
+	var oldest *knownAddress
+	for i := 0; i < oldBucketSize; i++ {
+		ka := newKnownAddress(testAddr(1000+i), testAddr(0))
+		ka.BucketType = bucketTypeOld
+		ka.LastAttempt = time.Now().Add(-time.Duration(i) * time.Minute)
+		if !book.addToOldBucket(ka, idx) {
+			t.Fatalf("fail to fill old bucket at %d", i)
+		}
+		oldest = ka
+	}
+
+	book.MarkGood(addr)
+	nNew, nOld := book.Sizes()
+	if nNew != 1 || nOld != oldBucketSize {
+		t.Fatalf("sizes = %d new %d old, want 1 new %d old", nNew, nOld, oldBucketSize)
+	}
+	if !book.addrLookup[addr.String()].isOld() {
+		t.Error("address not promoted")
+	}
+	if !oldest.isNew() || len(oldest.Buckets) != 1 || oldest.Buckets[0] != freedBucket {
+		t.Errorf("oldest entry in %v of type %d, want new bucket %d", oldest.Buckets, oldest.BucketType, freedBucket)
+	}
+	if len(book.bucketsOld[idx]) != oldBucketSize {
+		t.Errorf("old bucket holds %d entries, want %d", len(book.bucketsOld[idx]), oldBucketSize)
+	}
+}
+
+func TestAddrBookGroupKey(t *testing.T) {
+	book := NewAddrBook("", false, 0)
+	cases := map[string]string{
+		"1.1.0.1":                              "1.1.0.0/16",
+		"1.1.200.3":                            "1.1.0.0/16",
+		"2001:db8:1:2::1":                      "2001:db8::/32",
+		"2001:470:1234::1":                     "2001:470:1000::/36", // Hurricane Electric
+		"2002:102:304::1":                      "1.2.0.0/16",         // 6to4
+		"64:ff9b::102:304":                     "1.2.0.0/16",         // NAT64
+		"2001:0:4136:e378:8000:63bf:3fff:fdd2": "192.0.0.0/16",       // teredo
+	}
+	for ip, expected := range cases {
+		if group := book.GroupKey(NewNetAddressIPPort(net.ParseIP(ip), 46656)); group != expected {
+			t.Errorf("%s: expected group %s, got %s", ip, expected, group)
+		}
+	}
+}
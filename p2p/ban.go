@@ -0,0 +1,115 @@
+package p2p
+
+import (
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Ban keeps a node IP or pubkey out of the switch until it expires.
+type Ban struct {
+	Key    string    `json:"key"` // IP string or node pubkey
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+}
+
+func (b *Ban) expired(now time.Time) bool {
+	return !now.Before(b.Until)
+}
+
+// BanIP refuses connections from and to the IP for the given duration.
+func (a *AddrBook) BanIP(ip net.IP, duration time.Duration, reason string) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.ban(ip.String(), duration, reason)
+}
+
+// BanPubKey refuses connections with the node for the given duration.
+func (a *AddrBook) BanPubKey(pubKey string, duration time.Duration, reason string) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.ban(pubKey, duration, reason)
+}
+
+// IsBanned returns the active ban on the IP string or pubkey, if any
+func (a *AddrBook) IsBanned(key string) (*Ban, bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	ban, ok := a.bans[key]
+	if !ok {
+		return nil, false
+	}
+	if ban.expired(time.Now()) {
+		a.unban(ban)
+		return nil, false
+	}
+	return ban, true
+}
+
+// ListBans returns every active ban
+func (a *AddrBook) ListBans() []*Ban {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	now := time.Now()
+	bans := []*Ban{}
+	for _, ban := range a.bans {
+		if !ban.expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	return bans
+}
+
+// MarkBad removes the address from the book and bans its IP.
+func (a *AddrBook) MarkBad(addr *NetAddress, duration time.Duration, reason string) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	a.removeAddress(addr)
+	a.ban(addr.IP.String(), duration, reason)
+}
+
+// RemoveAddress removes the address from the book.
+func (a *AddrBook) RemoveAddress(addr *NetAddress) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.removeAddress(addr)
+}
+
+func (a *AddrBook) removeAddress(addr *NetAddress) {
+	if ka := a.addrLookup[addr.String()]; ka != nil {
+		a.removeFromAllBuckets(ka)
+	}
+}
+
+func (a *AddrBook) ban(key string, duration time.Duration, reason string) {
+	now := time.Now()
+	until := now.Add(duration)
+	if ban, ok := a.bans[key]; ok && ban.Until.After(until) {
+		return
+	}
+
+	a.bans[key] = &Ban{Key: key, Reason: reason, Since: now, Until: until}
+	log.WithFields(log.Fields{"key": key, "reason": reason, "until": until}).Info("banned peer")
+}
+
+func (a *AddrBook) unban(ban *Ban) {
+	delete(a.bans, ban.Key)
+	log.WithFields(log.Fields{"key": ban.Key, "reason": ban.Reason}).Info("ban expired")
+}
+
+func (a *AddrBook) purgeExpiredBans() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	now := time.Now()
+	for _, ban := range a.bans {
+		if ban.expired(now) {
+			a.unban(ban)
+		}
+	}
+}
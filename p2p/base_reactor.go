@@ -8,7 +8,7 @@ import (
 
 //Reactor is responsible for handling incoming messages of one or more `Channels`
 type Reactor interface {
-	//cmn.Service // Start, Stop
+	cmn.Service // Start, Stop
 
 	// SetSwitch allows setting a switch.
 	SetSwitch(*Switch)
@@ -55,7 +55,7 @@ func (br *BaseReactor) SetSwitch(sw *Switch) {
 func (*BaseReactor) GetChannels() []*connection.ChannelDescriptor { return nil }
 
 //AddPeer is called by the switch when a new peer is added
-func (*BaseReactor) AddPeer(peer *Peer) {}
+func (*BaseReactor) AddPeer(peer *Peer) error { return nil }
 
 //RemovePeer is called by the switch when the peer is stopped (due to error or other reason)
 func (*BaseReactor) RemovePeer(peer *Peer, reason interface{}) {}
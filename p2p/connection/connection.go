@@ -2,6 +2,7 @@ package connection
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -10,6 +11,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	wire "github.com/tendermint/go-wire"
 	cmn "github.com/tendermint/tmlibs/common"
@@ -21,7 +23,6 @@ const (
 	minReadBufferSize  = 1024
 	minWriteBufferSize = 65536
 	updateState        = 2 * time.Second
-	pingTimeout        = 40 * time.Second
 	flushThrottle      = 100 * time.Millisecond
 
 	defaultSendQueueCapacity   = 1
@@ -30,8 +31,12 @@ const (
 	defaultRecvMessageCapacity = 22020096      // 21MB
 	defaultRecvRate            = int64(512000) // 500KB/s
 	defaultSendTimeout         = 10 * time.Second
+	defaultPingInterval        = 40 * time.Second
+	defaultPongTimeout         = 30 * time.Second
 )
 
+var errPongTimeout = errors.New("pong timeout")
+
 type receiveCbFunc func(chID byte, msgBytes []byte)
 type errorCbFunc func(interface{})
 
@@ -70,6 +75,8 @@ type MConnection struct {
 	recvMonitor *flow.Monitor
 	send        chan struct{}
 	pong        chan struct{}
+	pongTimeout chan struct{}
+	ping        pingTracker
 	channels    []*Channel
 	channelsIdx map[byte]*Channel
 	onReceive   receiveCbFunc
@@ -85,15 +92,19 @@ type MConnection struct {
 
 // MConnConfig is a MConnection configuration.
 type MConnConfig struct {
-	SendRate int64 `mapstructure:"send_rate"`
-	RecvRate int64 `mapstructure:"recv_rate"`
+	SendRate     int64         `mapstructure:"send_rate"`
+	RecvRate     int64         `mapstructure:"recv_rate"`
+	PingInterval time.Duration `mapstructure:"ping_interval"`
+	PongTimeout  time.Duration `mapstructure:"pong_timeout"` // stop the connection when a ping is unanswered for this long
 }
 
 // DefaultMConnConfig returns the default config.
 func DefaultMConnConfig() *MConnConfig {
 	return &MConnConfig{
-		SendRate: defaultSendRate,
-		RecvRate: defaultRecvRate,
+		SendRate:     defaultSendRate,
+		RecvRate:     defaultRecvRate,
+		PingInterval: defaultPingInterval,
+		PongTimeout:  defaultPongTimeout,
 	}
 }
 
@@ -117,11 +128,12 @@ func NewMConnectionWithConfig(conn net.Conn, chDescs []*ChannelDescriptor, onRec
 		recvMonitor: flow.New(0, 0),
 		send:        make(chan struct{}, 1),
 		pong:        make(chan struct{}),
+		pongTimeout: make(chan struct{}, 1),
 		onReceive:   onReceive,
 		onError:     onError,
 		config:      config,
 
-		pingTimer:    time.NewTicker(pingTimeout),
+		pingTimer:    time.NewTicker(config.PingInterval),
 		chStatsTimer: time.NewTicker(updateState),
 	}
 
@@ -155,6 +167,7 @@ func (c *MConnection) OnStart() error {
 func (c *MConnection) OnStop() {
 	c.BaseService.OnStop()
 	c.flushTimer.Stop()
+	c.pingTimer.Stop()
 	if c.quit != nil {
 		close(c.quit)
 	}
@@ -297,10 +310,27 @@ FOR_LOOP:
 				channel.updateStats()
 			}
 		case <-c.pingTimer.C:
+			// the pong timeout handles a ping still unanswered
+			if !c.ping.sent(time.Now()) {
+				break
+			}
 			log.Debug("Send Ping")
 			wire.WriteByte(packetTypePing, c.bufWriter, &n, &err)
 			c.sendMonitor.Update(int(n))
 			c.flush()
+			time.AfterFunc(c.config.PongTimeout, func() {
+				select {
+				case c.pongTimeout <- struct{}{}:
+				default:
+				}
+			})
+		case <-c.pongTimeout:
+			if c.ping.expired(c.config.PongTimeout, time.Now()) {
+				log.WithField("conn", c).Info("Pong timeout, stopping the connection")
+				pongTimeouts.Inc()
+				c.stopForError(errPongTimeout)
+				break FOR_LOOP
+			}
 		case <-c.pong:
 			log.Debug("Send Pong")
 			wire.WriteByte(packetTypePong, c.bufWriter, &n, &err)
@@ -387,6 +417,7 @@ func (c *MConnection) sendMsgPacket() bool {
 		return true
 	}
 	c.sendMonitor.Update(int(n))
+	leastChannel.sentBytes.Add(float64(n))
 	c.flushTimer.Set()
 	return false
 }
@@ -441,8 +472,13 @@ FOR_LOOP:
 			log.Debug("Receive Ping")
 			c.pong <- struct{}{}
 		case packetTypePong:
-			// do nothing
-			log.Debug("Receive Pong")
+			rtt, ok := c.ping.received(time.Now())
+			if !ok {
+				log.Debug("Receive unsolicited Pong")
+				break
+			}
+			log.WithField("rtt", rtt).Debug("Receive Pong")
+			pingRTT.Observe(rtt.Seconds())
 		case packetTypeMsg:
 			pkt, n, err := msgPacket{}, int(0), error(nil)
 			wire.ReadBinaryPtr(&pkt, c.bufReader, maxMsgPacketTotalSize, &n, &err)
@@ -461,6 +497,8 @@ FOR_LOOP:
 			if !ok || channel == nil {
 				cmn.PanicQ(cmn.Fmt("Unknown channel %X", pkt.ChannelID))
 			}
+			// with the packet type byte, as counted on the sending side
+			channel.recvBytes.Add(float64(n + 1))
 			msgBytes, err := channel.recvMsgPacket(pkt)
 			if err != nil {
 				if c.IsRunning() {
@@ -495,6 +533,7 @@ type ConnectionStatus struct {
 	SendMonitor flow.Status
 	RecvMonitor flow.Status
 	Channels    []ChannelStatus
+	Ping        PingStatus
 }
 
 type ChannelStatus struct {
@@ -509,6 +548,7 @@ func (c *MConnection) Status() ConnectionStatus {
 	var status ConnectionStatus
 	status.SendMonitor = c.sendMonitor.Status()
 	status.RecvMonitor = c.recvMonitor.Status()
+	status.Ping = c.ping.Status()
 	status.Channels = make([]ChannelStatus, len(c.channels))
 	for i, channel := range c.channels {
 		status.Channels[i] = ChannelStatus{
@@ -556,6 +596,8 @@ type Channel struct {
 	sending       []byte
 	priority      int
 	recentlySent  int64 // exponential moving average
+	sentBytes     prometheus.Counter
+	recvBytes     prometheus.Counter
 }
 
 func newChannel(conn *MConnection, desc *ChannelDescriptor) *Channel {
@@ -570,6 +612,8 @@ func newChannel(conn *MConnection, desc *ChannelDescriptor) *Channel {
 		sendQueue: make(chan []byte, desc.SendQueueCapacity),
 		recving:   make([]byte, 0, desc.RecvBufferCapacity),
 		priority:  desc.Priority,
+		sentBytes: channelSentBytes.WithLabelValues(channelLabel(desc.ID)),
+		recvBytes: channelRecvBytes.WithLabelValues(channelLabel(desc.ID)),
 	}
 }
 
@@ -0,0 +1,43 @@
+package connection
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	channelSentBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nodestats",
+		Subsystem: "connection",
+		Name:      "channel_sent_bytes_total",
+		Help:      "Number of msg packet bytes sent by channel, pings and pongs excluded.",
+	}, []string{"channel"})
+	channelRecvBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nodestats",
+		Subsystem: "connection",
+		Name:      "channel_received_bytes_total",
+		Help:      "Number of msg packet bytes received by channel, pings and pongs excluded.",
+	}, []string{"channel"})
+	pingRTT = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "nodestats",
+		Subsystem: "connection",
+		Name:      "ping_rtt_seconds",
+		Help:      "Round trip time of the pings sent to peers.",
+		Buckets:   []float64{.01, .025, .05, .1, .2, .3, .5, 1, 2, 5},
+	})
+	pongTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nodestats",
+		Subsystem: "connection",
+		Name:      "pong_timeouts_total",
+		Help:      "Number of connections stopped because a ping went unanswered.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(channelSentBytes, channelRecvBytes, pingRTT, pongTimeouts)
+}
+
+func channelLabel(chID byte) string {
+	return fmt.Sprintf("%#02x", chID)
+}
@@ -0,0 +1,52 @@
+package connection
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestChannelByteMetrics(t *testing.T) {
+	const chID = byte(0x7e)
+	sentBefore := testutil.ToFloat64(channelSentBytes.WithLabelValues(channelLabel(chID)))
+	recvBefore := testutil.ToFloat64(channelRecvBytes.WithLabelValues(channelLabel(chID)))
+
+	received := make(chan []byte, 1)
+	chDescs := []*ChannelDescriptor{{ID: chID, Priority: 1}}
+	client, server := net.Pipe()
+	clientConn := NewMConnection(client, chDescs, func(byte, []byte) {}, func(interface{}) {})
+	serverConn := NewMConnection(server, chDescs, func(_ byte, msg []byte) { received <- msg }, func(interface{}) {})
+	if _, err := clientConn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Stop()
+	if _, err := serverConn.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer serverConn.Stop()
+
+	// split in three msg packets
+	if !clientConn.Send(chID, make([]byte, 2*maxMsgPacketPayloadSize+1)) {
+		t.Fatal("fail to send the message")
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the message")
+	}
+
+	sent := testutil.ToFloat64(channelSentBytes.WithLabelValues(channelLabel(chID))) - sentBefore
+	recv := testutil.ToFloat64(channelRecvBytes.WithLabelValues(channelLabel(chID))) - recvBefore
+	if sent <= 2*maxMsgPacketPayloadSize || recv != sent {
+		t.Errorf("expected the same bytes on both sides, sent %v and received %v", sent, recv)
+	}
+	// no ping went out yet, the monitors saw the msg packets only
+	if monitored := clientConn.Status().SendMonitor.Bytes; float64(monitored) != sent {
+		t.Errorf("expected the %v bytes of the send monitor, got %v", monitored, sent)
+	}
+	if monitored := serverConn.Status().RecvMonitor.Bytes; float64(monitored) != recv {
+		t.Errorf("expected the %v bytes of the receive monitor, got %v", monitored, recv)
+	}
+}
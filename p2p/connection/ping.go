@@ -0,0 +1,88 @@
+package connection
+
+import (
+	"sync"
+	"time"
+)
+
+// ewmaWeight is the weight of the newest sample in PingStatus.EWMARTT, the
+// smoothing TCP uses for its round trip estimate
+const ewmaWeight = 0.125
+
+// PingStatus holds the round trip times measured with ping/pong packets
+type PingStatus struct {
+	Sent     int64 // pings sent
+	Received int64 // pongs received in reply
+	LastRTT  time.Duration
+	MinRTT   time.Duration
+	MaxRTT   time.Duration
+	AvgRTT   time.Duration
+	EWMARTT  time.Duration
+}
+
+// pingTracker times the round trip of the outstanding ping, only one ping is
+// in flight at a time so every pong matches the latest ping.
+type pingTracker struct {
+	mtx    sync.Mutex
+	sentAt time.Time // zero when no ping is outstanding
+	rttSum time.Duration
+	status PingStatus
+}
+
+// sent records a ping sent at now, it returns false when the previous ping
+// is still unanswered and no new one should be sent.
+func (t *pingTracker) sent(now time.Time) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if !t.sentAt.IsZero() {
+		return false
+	}
+	t.sentAt = now
+	t.status.Sent++
+	return true
+}
+
+// received accounts for a pong received at now and returns the round trip,
+// false for a pong we didn't ask for.
+func (t *pingTracker) received(now time.Time) (time.Duration, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.sentAt.IsZero() {
+		return 0, false
+	}
+	rtt := now.Sub(t.sentAt)
+	t.sentAt = time.Time{}
+
+	s := &t.status
+	s.Received++
+	t.rttSum += rtt
+	s.LastRTT = rtt
+	s.AvgRTT = t.rttSum / time.Duration(s.Received)
+	if s.Received == 1 {
+		s.MinRTT, s.MaxRTT, s.EWMARTT = rtt, rtt, rtt
+		return rtt, true
+	}
+	if rtt < s.MinRTT {
+		s.MinRTT = rtt
+	}
+	if rtt > s.MaxRTT {
+		s.MaxRTT = rtt
+	}
+	s.EWMARTT = time.Duration((1-ewmaWeight)*float64(s.EWMARTT) + ewmaWeight*float64(rtt))
+	return rtt, true
+}
+
+// expired reports whether the outstanding ping went unanswered for timeout
+func (t *pingTracker) expired(timeout time.Duration, now time.Time) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return !t.sentAt.IsZero() && now.Sub(t.sentAt) >= timeout
+}
+
+func (t *pingTracker) Status() PingStatus {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.status
+}
@@ -0,0 +1,71 @@
+package connection
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPingTrackerRTT(t *testing.T) {
+	tracker := &pingTracker{}
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, ok := tracker.received(start); ok {
+		t.Error("expected an unsolicited pong to be ignored")
+	}
+
+	now := start
+	for _, rtt := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 40 * time.Millisecond} {
+		if !tracker.sent(now) {
+			t.Fatal("expected the ping to be sent")
+		}
+		if tracker.sent(now.Add(time.Millisecond)) {
+			t.Fatal("expected no ping while one is outstanding")
+		}
+		now = now.Add(rtt)
+		if got, ok := tracker.received(now); !ok || got != rtt {
+			t.Fatalf("expected rtt %v, got %v", rtt, got)
+		}
+		now = now.Add(time.Minute)
+	}
+
+	status := tracker.Status()
+	expected := PingStatus{
+		Sent:     3,
+		Received: 3,
+		LastRTT:  40 * time.Millisecond,
+		MinRTT:   40 * time.Millisecond,
+		MaxRTT:   200 * time.Millisecond,
+		AvgRTT:   340 * time.Millisecond / 3,
+		// 100ms, then 7/8 * 100ms + 1/8 * 200ms, then 7/8 * 112.5ms + 1/8 * 40ms
+		EWMARTT: 103437500 * time.Nanosecond,
+	}
+	if status != expected {
+		t.Errorf("expected %+v, got %+v", expected, status)
+	}
+}
+
+func TestPingTrackerTimeout(t *testing.T) {
+	tracker := &pingTracker{}
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeout := 45 * time.Second
+
+	if tracker.expired(timeout, start.Add(time.Hour)) {
+		t.Error("expected no timeout without an outstanding ping")
+	}
+
+	tracker.sent(start)
+	if tracker.expired(timeout, start.Add(timeout-time.Millisecond)) {
+		t.Error("expected no timeout before the deadline")
+	}
+	if !tracker.expired(timeout, start.Add(timeout)) {
+		t.Error("expected a timeout at the deadline")
+	}
+
+	tracker.received(start.Add(timeout + time.Second))
+	if tracker.expired(timeout, start.Add(2*timeout)) {
+		t.Error("expected no timeout once the pong arrived")
+	}
+	if status := tracker.Status(); status.Sent != 1 || status.Received != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
@@ -0,0 +1,178 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nodestats/p2p/connection"
+)
+
+// EventType identifies a peer lifecycle event
+type EventType int
+
+const (
+	// EventDialStarted is emitted before an outbound dial
+	EventDialStarted EventType = iota
+	// EventDialFailed is emitted when an outbound dial doesn't end with an added peer
+	EventDialFailed
+	// EventHandshakeCompleted is emitted once the peer's NodeInfo is received
+	// and the peer passed the compatibility and admission checks
+	EventHandshakeCompleted
+	// EventPeerAdded is emitted once the peer is started and known to the reactors
+	EventPeerAdded
+	// EventPeerRemoved is emitted when the peer is stopped
+	EventPeerRemoved
+	// EventHandshakeRejected is emitted in observation mode when the peer's
+	// NodeInfo is not compatible with ours
+	EventHandshakeRejected
+	// EventAddrsReceived is emitted by the PEX reactor when a peer answers
+	// with the addresses it knows
+	EventAddrsReceived
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventDialStarted:
+		return "dial_started"
+	case EventDialFailed:
+		return "dial_failed"
+	case EventHandshakeCompleted:
+		return "handshake_completed"
+	case EventPeerAdded:
+		return "peer_added"
+	case EventPeerRemoved:
+		return "peer_removed"
+	case EventHandshakeRejected:
+		return "handshake_rejected"
+	case EventAddrsReceived:
+		return "addrs_received"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a peer lifecycle change published by the switch. Only the fields
+// relevant to the event type are set.
+type Event struct {
+	Type     EventType              `json:"type"`
+	Time     time.Time              `json:"time"`
+	Addr     *NetAddress            `json:"addr,omitempty"`
+	NodeInfo *NodeInfo              `json:"node_info,omitempty"`
+	Outbound bool                   `json:"outbound"`
+	Err      error                  `json:"-"`              // dial failed, handshake rejected
+	Reason   interface{}            `json:"reason"`         // peer removed
+	Duration time.Duration          `json:"duration"`       // peer removed, how long the peer was connected
+	Addrs    []*NetAddress          `json:"addrs"`          // addrs received
+	Ping     *connection.PingStatus `json:"ping,omitempty"` // peer removed, round trip times of the connection
+}
+
+var eventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Subsystem: "p2p",
+	Name:      "events_dropped_total",
+	Help:      "Number of peer events dropped because the subscriber buffer was full.",
+}, []string{"subscriber"})
+
+func init() {
+	prometheus.MustRegister(eventsDropped)
+}
+
+// eventBus fans out events to buffered subscriber channels and to handlers
+// called in the publishing goroutine. A channel subscriber that can't keep up
+// loses events instead of blocking the switch.
+type eventBus struct {
+	mtx      sync.RWMutex
+	subs     map[string]chan *Event
+	handlers map[string]func(*Event)
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subs:     make(map[string]chan *Event),
+		handlers: make(map[string]func(*Event)),
+	}
+}
+
+func (b *eventBus) subscribeFunc(name string, handler func(*Event)) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.handlers[name] = handler
+}
+
+func (b *eventBus) subscribe(name string, capacity int) <-chan *Event {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if ch, ok := b.subs[name]; ok {
+		close(ch)
+	}
+	ch := make(chan *Event, capacity)
+	b.subs[name] = ch
+	return ch
+}
+
+func (b *eventBus) unsubscribe(name string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if ch, ok := b.subs[name]; ok {
+		close(ch)
+		delete(b.subs, name)
+	}
+	delete(b.handlers, name)
+}
+
+func (b *eventBus) publish(event *Event) {
+	b.mtx.RLock()
+	handlers := make([]func(*Event), 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	for name, ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+			eventsDropped.WithLabelValues(name).Inc()
+		}
+	}
+	b.mtx.RUnlock()
+
+	// handlers run outside the lock so they may subscribe or unsubscribe
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// Subscribe returns a buffered channel receiving every peer event. Events are
+// dropped when the channel is full. Subscribing twice with the same name
+// closes the previous channel.
+func (sw *Switch) Subscribe(name string, capacity int) <-chan *Event {
+	return sw.events.subscribe(name, capacity)
+}
+
+// SubscribeFunc registers a handler called with every peer event, nothing is
+// dropped. The handler runs in the goroutine publishing the event, before the
+// switch goes on, e.g. before a rejected connection is closed, so it must not
+// block: slow work belongs behind a Subscribe channel. A handler may still be
+// called once for an event published while it is unsubscribed.
+func (sw *Switch) SubscribeFunc(name string, handler func(*Event)) {
+	sw.events.subscribeFunc(name, handler)
+}
+
+// Unsubscribe closes the subscriber's channel or removes its handler
+func (sw *Switch) Unsubscribe(name string) {
+	sw.events.unsubscribe(name)
+}
+
+// Publish sends the event to every subscriber, reactors use it to report
+// what they learn from peers.
+func (sw *Switch) Publish(event *Event) {
+	sw.publish(event)
+}
+
+func (sw *Switch) publish(event *Event) {
+	event.Time = time.Now()
+	sw.events.publish(event)
+}
@@ -0,0 +1,148 @@
+package p2p
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// observedAddrPrefix marks the NodeInfo.Other entry carrying the address
+	// the sender sees the receiver at
+	observedAddrPrefix = "observed_addr="
+	// minObservedAddrVotes is the number of distinct network groups that must
+	// observe the same IP before we advertise it
+	minObservedAddrVotes = 3
+	// observedAddrTTL is how long an observation counts as a vote
+	observedAddrTTL = 6 * time.Hour
+	// maxObservedAddrVoters bounds the number of network groups tracked
+	maxObservedAddrVoters = 1000
+)
+
+// withObservedAddr returns a copy of info telling the peer the address we see it at
+func (info *NodeInfo) withObservedAddr(addr string) *NodeInfo {
+	c := *info
+	c.Other = append(append([]string{}, info.Other...), observedAddrPrefix+addr)
+	return &c
+}
+
+// takeObservedAddr removes the observed address entry from Other and returns
+// it, "" if the peer didn't send one. It is per connection data and must not
+// be kept with the rest of the NodeInfo.
+func (info *NodeInfo) takeObservedAddr() string {
+	observed := ""
+	other := make([]string, 0, len(info.Other))
+	for _, o := range info.Other {
+		if strings.HasPrefix(o, observedAddrPrefix) {
+			observed = strings.TrimPrefix(o, observedAddrPrefix)
+			continue
+		}
+		other = append(other, o)
+	}
+	info.Other = other
+	return observed
+}
+
+// addrVoter elects our public IP from what our peers observe. Every network
+// group votes with its latest observation only, so a single operator can't
+// steer the result and a group that sees a new IP moves its vote. Votes older
+// than observedAddrTTL are dropped and at most maxObservedAddrVoters groups
+// are tracked, the oldest vote making room for a new group.
+//
+// Only the IP is voted on. Outbound connections are seen from an ephemeral
+// port, so the port we advertise stays the one of our listen address, the
+// gateway port when the listener mapped one.
+type addrVoter struct {
+	mtx    sync.Mutex
+	votes  map[string]*addrVote // voter group -> its latest observation
+	winner string
+}
+
+// addrVote is the IP a network group observed us at and when
+type addrVote struct {
+	ip   string
+	time time.Time
+}
+
+func newAddrVoter() *addrVoter {
+	return &addrVoter{votes: make(map[string]*addrVote)}
+}
+
+// vote records the observation and returns the winning IP when it changed
+func (v *addrVoter) vote(ip net.IP, voterGroup string, now time.Time) (net.IP, bool) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	if _, ok := v.votes[voterGroup]; !ok && len(v.votes) >= maxObservedAddrVoters {
+		v.dropOldest()
+	}
+	v.votes[voterGroup] = &addrVote{ip: ip.String(), time: now}
+
+	tally := make(map[string]int)
+	for group, vote := range v.votes {
+		if now.Sub(vote.time) > observedAddrTTL {
+			delete(v.votes, group)
+			continue
+		}
+		tally[vote.ip]++
+	}
+
+	winner, best := "", 0
+	for candidate, n := range tally {
+		if n > best {
+			winner, best = candidate, n
+		}
+	}
+	if best < minObservedAddrVotes || winner == v.winner || tally[v.winner] >= best {
+		return nil, false
+	}
+	v.winner = winner
+	return net.ParseIP(winner), true
+}
+
+func (v *addrVoter) dropOldest() {
+	oldest := ""
+	for group, vote := range v.votes {
+		if oldest == "" || vote.time.Before(v.votes[oldest].time) {
+			oldest = group
+		}
+	}
+	delete(v.votes, oldest)
+}
+
+// recordObservedAddr counts the address the peer sees us at and advertises
+// the winner of the vote once it changes.
+func (sw *Switch) recordObservedAddr(observed string, peerAddr *NetAddress) {
+	if observed == "" || peerAddr == nil {
+		return
+	}
+	host, _, err := net.SplitHostPort(observed)
+	if err != nil {
+		log.WithFields(log.Fields{"observed": observed, "peer": peerAddr}).Debug("invalid observed address")
+		return
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !NewNetAddressIPPort(ip, 0).Routable() {
+		return
+	}
+
+	winner, changed := sw.addrVoter.vote(ip, sw.addrBook.GroupKey(peerAddr), time.Now())
+	if !changed {
+		return
+	}
+
+	// only the IP changes, see addrVoter
+	sw.nodeInfoMtx.Lock()
+	ours := *sw.nodeInfo
+	ours.ListenAddr = net.JoinHostPort(winner.String(), strconv.Itoa(sw.nodeInfo.ListenPort()))
+	sw.nodeInfo = &ours
+	sw.nodeInfoMtx.Unlock()
+
+	addr := NewNetAddressIPPort(winner, uint16(ours.ListenPort()))
+	sw.addrBook.AddOurAddress(addr)
+	log.WithField("address", addr).Info("Advertising the external address observed by peers")
+}
@@ -0,0 +1,80 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAddrVoterElection(t *testing.T) {
+	v := newAddrVoter()
+	now := time.Now()
+	ipA, ipB := net.ParseIP("8.8.8.8"), net.ParseIP("9.9.9.9")
+
+	// a single group voting many times is a single vote
+	for i := 0; i < 5; i++ {
+		if _, changed := v.vote(ipA, "1.1.0.0/16", now); changed {
+			t.Fatal("expected no winner with one voter group")
+		}
+	}
+	v.vote(ipA, "1.2.0.0/16", now)
+	winner, changed := v.vote(ipA, "1.3.0.0/16", now)
+	if !changed || !winner.Equal(ipA) {
+		t.Fatalf("expected %v to win with %d groups, got %v", ipA, minObservedAddrVotes, winner)
+	}
+
+	// ties keep the current winner
+	v.vote(ipB, "2.1.0.0/16", now)
+	v.vote(ipB, "2.2.0.0/16", now)
+	if _, changed := v.vote(ipB, "2.3.0.0/16", now); changed {
+		t.Fatal("expected a tie to keep the current winner")
+	}
+
+	// a group seeing the new IP moves its vote
+	winner, changed = v.vote(ipB, "1.1.0.0/16", now)
+	if !changed || !winner.Equal(ipB) {
+		t.Fatalf("expected %v to win once a group moved its vote, got %v", ipB, winner)
+	}
+}
+
+func TestAddrVoterExpiry(t *testing.T) {
+	v := newAddrVoter()
+	now := time.Now()
+	ipA, ipB := net.ParseIP("8.8.8.8"), net.ParseIP("9.9.9.9")
+
+	v.vote(ipA, "1.1.0.0/16", now)
+	v.vote(ipA, "1.2.0.0/16", now)
+	if _, changed := v.vote(ipA, "1.3.0.0/16", now.Add(observedAddrTTL+time.Second)); changed {
+		t.Fatal("expected expired votes not to count")
+	}
+	if len(v.votes) != 1 {
+		t.Errorf("expected the expired votes to be dropped, %d left", len(v.votes))
+	}
+
+	later := now.Add(observedAddrTTL + time.Minute)
+	v.vote(ipB, "2.1.0.0/16", later)
+	v.vote(ipB, "2.2.0.0/16", later)
+	if winner, changed := v.vote(ipB, "2.3.0.0/16", later); !changed || !winner.Equal(ipB) {
+		t.Errorf("expected %v to win, got %v", ipB, winner)
+	}
+}
+
+func TestAddrVoterCap(t *testing.T) {
+	v := newAddrVoter()
+	now := time.Now()
+	ip := net.ParseIP("8.8.8.8")
+
+	for i := 0; i < maxObservedAddrVoters+10; i++ {
+		v.vote(ip, fmt.Sprintf("group%d", i), now.Add(time.Duration(i)*time.Second))
+	}
+	if len(v.votes) != maxObservedAddrVoters {
+		t.Fatalf("expected %d tracked groups, got %d", maxObservedAddrVoters, len(v.votes))
+	}
+	if _, ok := v.votes["group9"]; ok {
+		t.Error("expected the oldest votes to be dropped")
+	}
+	if _, ok := v.votes["group10"]; !ok {
+		t.Error("expected the newer votes to be kept")
+	}
+}
@@ -2,27 +2,155 @@ package p2p
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 	cmn "github.com/tendermint/tmlibs/common"
 )
 
 type addrBookJSON struct {
 	Key   string
 	Addrs []*knownAddress
+	Bans  []*Ban
 }
 
 // SaveToFile will save the address book to a json file in disk
 func (a *AddrBook) SaveToFile() error {
+	a.saveMtx.Lock()
+	defer a.saveMtx.Unlock()
+
+	rawDats, err := a.marshalJSON()
+	if err != nil {
+		return err
+	}
+	return cmn.WriteFileAtomic(a.filePath, rawDats, 0644)
+}
+
+func (a *AddrBook) marshalJSON() ([]byte, error) {
 	a.mtx.RLock()
 	defer a.mtx.RUnlock()
 
-	aJSON := &addrBookJSON{Key: a.key, Addrs: []*knownAddress{}}
+	aJSON := &addrBookJSON{Key: a.key, Addrs: []*knownAddress{}, Bans: []*Ban{}}
 	for _, ka := range a.addrLookup {
 		aJSON.Addrs = append(aJSON.Addrs, ka)
 	}
+	for _, ban := range a.bans {
+		aJSON.Bans = append(aJSON.Bans, ban)
+	}
+	return json.MarshalIndent(aJSON, "", "\t")
+}
+
+// loadFromFile restores the address book from disk. A missing file leaves the
+// book empty, a corrupt one is moved aside and the book starts fresh.
+func (a *AddrBook) loadFromFile() error {
+	if !cmn.FileExists(a.filePath) {
+		return nil
+	}
 
-	rawDats, err := json.MarshalIndent(aJSON, "", "\t")
+	rawDats, err := ioutil.ReadFile(a.filePath)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "read address book")
+	}
+
+	aJSON := &addrBookJSON{}
+	if err = json.Unmarshal(rawDats, aJSON); err == nil && aJSON.Key == "" {
+		err = errors.New("missing address book key")
+	}
+	if err != nil {
+		backupPath := fmt.Sprintf("%s.%d.bak", a.filePath, time.Now().Unix())
+		if renameErr := os.Rename(a.filePath, backupPath); renameErr != nil {
+			return errors.Wrap(renameErr, "backup corrupt address book")
+		}
+		log.WithFields(log.Fields{"err": err, "backup": backupPath}).Warn("address book is corrupt, starting fresh")
+		return nil
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	a.key = aJSON.Key
+	for _, ka := range aJSON.Addrs {
+		a.restoreKnownAddress(ka)
+	}
+	now := time.Now()
+	for _, ban := range aJSON.Bans {
+		if ban != nil && ban.Key != "" && !ban.expired(now) {
+			a.bans[ban.Key] = ban
+		}
+	}
+	log.WithFields(log.Fields{"new": a.nNew, "old": a.nOld, "bans": len(a.bans)}).Info("loaded address book from file")
+	return nil
+}
+
+// restoreKnownAddress puts a loaded address back into the buckets recorded
+// for it. Unknown or full buckets are skipped and the references are trimmed
+// to one old bucket or maxNewBucketsPerAddress new ones, an entry left
+// without bucket is dropped.
+func (a *AddrBook) restoreKnownAddress(ka *knownAddress) {
+	if ka == nil || ka.Addr == nil || ka.Src == nil {
+		return
+	}
+
+	addrStr := ka.Addr.String()
+	if _, ok := a.addrLookup[addrStr]; ok {
+		return
+	}
+
+	maxBuckets := maxNewBucketsPerAddress
+	if ka.isOld() {
+		maxBuckets = 1
+	}
+	buckets := ka.Buckets
+	ka.Buckets = nil
+	for _, bucketIdx := range buckets {
+		if len(ka.Buckets) == maxBuckets {
+			log.WithFields(log.Fields{"address": addrStr, "type": ka.BucketType, "buckets": buckets}).Warn("trim address book buckets")
+			break
+		}
+		if !a.validBucket(ka.BucketType, bucketIdx) {
+			log.WithFields(log.Fields{"address": addrStr, "type": ka.BucketType, "bucket": bucketIdx}).Warn("skip invalid address book bucket")
+			continue
+		}
+		bucket := a.getBucket(ka.BucketType, bucketIdx)
+		if len(bucket) >= bucketSize(ka.BucketType) {
+			log.WithFields(log.Fields{"address": addrStr, "type": ka.BucketType, "bucket": bucketIdx}).Warn("skip full address book bucket")
+			continue
+		}
+		if ka.addBucketRef(bucketIdx) < 0 {
+			continue
+		}
+		bucket[addrStr] = ka
+	}
+	if len(ka.Buckets) == 0 {
+		return
+	}
+
+	a.addrLookup[addrStr] = ka
+	if ka.isOld() {
+		a.nOld++
+	} else {
+		a.nNew++
+	}
+}
+
+func bucketSize(bucketType byte) int {
+	if bucketType == bucketTypeOld {
+		return oldBucketSize
+	}
+	return newBucketSize
+}
+
+func (a *AddrBook) validBucket(bucketType byte, bucketIdx int) bool {
+	switch bucketType {
+	case bucketTypeNew:
+		return bucketIdx >= 0 && bucketIdx < len(a.bucketsNew)
+	case bucketTypeOld:
+		return bucketIdx >= 0 && bucketIdx < len(a.bucketsOld)
+	default:
+		return false
 	}
-	return cmn.WriteFileAtomic(a.filePath, rawDats, 0644)
 }
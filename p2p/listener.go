@@ -0,0 +1,320 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/nodestats/p2p/nat"
+)
+
+const (
+	numBufferedConnections = 10
+	tryListenSeconds       = 5
+
+	// accept errors are retried after a delay doubling up to the maximum
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+
+	portMappingDesc     = "nodestats"
+	portMappingLifetime = 20 * time.Minute // renewed every half lifetime
+)
+
+// Listener is a network listener for stream-oriented protocols, providing
+// convenient methods to get listener's internal and external addresses.
+// Clients are supposed to read incoming connections from a channel, returned
+// by Connections() method.
+type Listener interface {
+	Connections() <-chan net.Conn
+	InternalAddress() *NetAddress
+	ExternalAddress() *NetAddress
+	String() string
+	Stop() bool
+}
+
+// DefaultListener implements Listener over a plain TCP socket.
+type DefaultListener struct {
+	cmn.BaseService
+
+	listener    net.Listener
+	intAddr     *NetAddress
+	extAddr     *NetAddress
+	connections chan net.Conn
+
+	gateway nat.NAT // nil unless the port is mapped on the gateway
+	intPort int
+	extPort int
+}
+
+func splitHostPort(addr string) (host string, port int) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		cmn.PanicSanity(err)
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil {
+		cmn.PanicSanity(err)
+	}
+	return host, port
+}
+
+// NewDefaultListener creates a listener from a protocol://host:port address.
+// Only the tcp protocol is supported. Unless skipUPNP is set the port is
+// mapped on the gateway with UPnP or NAT-PMP and the gateway external IP is
+// used as our external address.
+func NewDefaultListener(laddr string, skipUPNP bool) (Listener, error) {
+	protocol, lAddr := cmn.ProtocolAndAddress(laddr)
+	if protocol != "tcp" {
+		return nil, fmt.Errorf("unsupported listener protocol %v", protocol)
+	}
+
+	// Create listener
+	var listener net.Listener
+	var err error
+	for i := 0; i < tryListenSeconds; i++ {
+		listener, err = net.Listen(protocol, lAddr)
+		if err == nil {
+			break
+		} else if i < tryListenSeconds-1 {
+			time.Sleep(time.Second * 1)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Actual listener local IP & port
+	listenerIP, listenerPort := splitHostPort(listener.Addr().String())
+	log.WithFields(log.Fields{"ip": listenerIP, "port": listenerPort}).Info("Local listener")
+
+	// Determine internal address...
+	intAddr, err := NewNetAddressString(lAddr)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	dl := &DefaultListener{
+		listener:    listener,
+		intAddr:     intAddr,
+		intPort:     listenerPort,
+		connections: make(chan net.Conn, numBufferedConnections),
+	}
+
+	// Determine external address...
+	if !skipUPNP {
+		dl.extAddr = dl.mapPort()
+	}
+
+	// Otherwise just use the local address...
+	if dl.extAddr == nil {
+		dl.extAddr = getNaiveExternalAddress(listenerPort)
+	}
+	if dl.extAddr == nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not determine external address")
+	}
+
+	dl.BaseService = *cmn.NewBaseService(nil, "DefaultListener", dl)
+	if _, err := dl.Start(); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return dl, nil
+}
+
+// OnStart implements BaseService
+func (l *DefaultListener) OnStart() error {
+	l.BaseService.OnStart()
+	go l.listenRoutine()
+	if l.gateway != nil {
+		go l.renewRoutine()
+	}
+	return nil
+}
+
+// OnStop implements BaseService
+func (l *DefaultListener) OnStop() {
+	l.BaseService.OnStop()
+	l.listener.Close()
+	if l.gateway != nil {
+		if err := l.gateway.DeletePortMapping("tcp", l.extPort, l.intPort); err != nil {
+			log.WithFields(log.Fields{"gateway": l.gateway, "err": err}).Warn("fail to remove port mapping")
+		}
+	}
+}
+
+// mapPort forwards the listen port on the gateway, it returns nil if no
+// gateway was found or the mapping failed.
+func (l *DefaultListener) mapPort() *NetAddress {
+	gateway, err := nat.Discover(nil)
+	if err != nil {
+		log.WithField("err", err).Info("no NAT gateway, skip port mapping")
+		return nil
+	}
+	return l.mapGateway(gateway)
+}
+
+// mapGateway forwards the listen port on gateway and returns the external
+// address, the gateway is kept for renewals only when both succeed.
+func (l *DefaultListener) mapGateway(gateway nat.NAT) *NetAddress {
+	extPort, err := gateway.AddPortMapping("tcp", l.intPort, l.intPort, portMappingDesc, portMappingLifetime)
+	if err != nil {
+		log.WithFields(log.Fields{"gateway": gateway, "err": err}).Error("fail to map listen port")
+		return nil
+	}
+
+	ip, err := gateway.ExternalIP()
+	if err != nil {
+		// without the IP the naive address is advertised, the mapping is useless
+		log.WithFields(log.Fields{"gateway": gateway, "err": err}).Error("fail to get external IP")
+		if err := gateway.DeletePortMapping("tcp", extPort, l.intPort); err != nil {
+			log.WithFields(log.Fields{"gateway": gateway, "err": err}).Warn("fail to remove port mapping")
+		}
+		return nil
+	}
+	l.gateway, l.extPort = gateway, extPort
+	log.WithFields(log.Fields{"gateway": gateway, "ip": ip, "port": extPort}).Info("Mapped listen port")
+	return NewNetAddressIPPort(ip, uint16(extPort))
+}
+
+// renewRoutine renews the port mapping before its lifetime runs out
+func (l *DefaultListener) renewRoutine() {
+	ticker := time.NewTicker(portMappingLifetime / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.renewPortMapping()
+		case <-l.Quit:
+			return
+		}
+	}
+}
+
+// renewPortMapping renews the mapping of the external port we advertise. The
+// port is part of the NodeInfo sent to peers and can't change, so when the
+// gateway maps another one instead that mapping is removed and the advertised
+// port requested once more.
+func (l *DefaultListener) renewPortMapping() {
+	for try := 0; try < 2; try++ {
+		extPort, err := l.gateway.AddPortMapping("tcp", l.extPort, l.intPort, portMappingDesc, portMappingLifetime)
+		if err != nil {
+			log.WithFields(log.Fields{"gateway": l.gateway, "err": err}).Error("fail to renew port mapping")
+			return
+		}
+		if extPort == l.extPort {
+			return
+		}
+
+		log.WithFields(log.Fields{"gateway": l.gateway, "advertised": l.extPort, "mapped": extPort}).Warn("gateway moved the port mapping")
+		if err := l.gateway.DeletePortMapping("tcp", extPort, l.intPort); err != nil {
+			log.WithFields(log.Fields{"gateway": l.gateway, "err": err}).Warn("fail to remove port mapping")
+		}
+	}
+	log.WithFields(log.Fields{"gateway": l.gateway, "port": l.extPort}).Error("advertised port is no longer mapped")
+}
+
+// Accept connections and pass on the channel
+func (l *DefaultListener) listenRoutine() {
+	var delay time.Duration
+	for {
+		conn, err := l.listener.Accept()
+		if !l.IsRunning() {
+			break // Go to cleanup
+		}
+
+		// listener wasn't stopped,
+		// yet we encountered an error.
+		if err != nil {
+			// back off on temporary errors such as running out of file
+			// descriptors, like net/http does
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = minAcceptDelay
+				} else if delay *= 2; delay > maxAcceptDelay {
+					delay = maxAcceptDelay
+				}
+				log.WithFields(log.Fields{"err": err, "retry": delay}).Error("listener accept failed")
+				select {
+				case <-time.After(delay):
+				case <-l.Quit:
+				}
+				continue
+			}
+			log.WithField("err", err).Error("listener accept failed, stop accepting connections")
+			break
+		}
+		delay = 0
+
+		l.connections <- conn
+	}
+
+	// Cleanup
+	close(l.connections)
+}
+
+// Connections returns a read-only channel of inbound connections.
+// A channel is closed when the listener is stopped.
+func (l *DefaultListener) Connections() <-chan net.Conn {
+	return l.connections
+}
+
+// InternalAddress returns the address the listener is bound to
+func (l *DefaultListener) InternalAddress() *NetAddress {
+	return l.intAddr
+}
+
+// ExternalAddress returns the address other nodes can reach us on
+func (l *DefaultListener) ExternalAddress() *NetAddress {
+	return l.extAddr
+}
+
+// NetListener returns the underlying net.Listener
+// NOTE: The returned listener is already Accept()'ing.
+// So it's not suitable to pass into http.Serve().
+func (l *DefaultListener) NetListener() net.Listener {
+	return l.listener
+}
+
+func (l *DefaultListener) String() string {
+	return fmt.Sprintf("Listener(@%v)", l.extAddr)
+}
+
+// getNaiveExternalAddress picks the first non-loopback IPv4 address of the host.
+// TODO: use syscalls: http://pastebin.com/9exZG4rh
+func getNaiveExternalAddress(port int) *NetAddress {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.WithField("err", err).Error("could not fetch interface addresses")
+		return nil
+	}
+
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		// skip loopback
+		if v4 := ipnet.IP.To4(); v4 == nil || v4[0] == 127 {
+			continue
+		}
+		return NewNetAddressIPPort(ipnet.IP, uint16(port))
+	}
+
+	// try again, but allowing loopback
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if v4 := ipnet.IP.To4(); v4 != nil {
+			return NewNetAddressIPPort(ipnet.IP, uint16(port))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package p2p
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/nodestats/p2p/nat"
+)
+
+// fakeNAT maps every request to the ports in mapTo, in order, the last one
+// is reused once they run out.
+type fakeNAT struct {
+	mtx     sync.Mutex
+	mapTo   []int
+	ipErr   error
+	added   []int
+	deleted []int
+}
+
+func (f *fakeNAT) ExternalIP() (net.IP, error) {
+	if f.ipErr != nil {
+		return nil, f.ipErr
+	}
+	return net.IPv4(8, 8, 8, 8), nil
+}
+
+func (f *fakeNAT) AddPortMapping(protocol string, extPort, intPort int, desc string, lifetime time.Duration) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	port := f.mapTo[0]
+	if len(f.mapTo) > 1 {
+		f.mapTo = f.mapTo[1:]
+	}
+	f.added = append(f.added, extPort)
+	return port, nil
+}
+
+func (f *fakeNAT) DeletePortMapping(protocol string, extPort, intPort int) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.deleted = append(f.deleted, extPort)
+	return nil
+}
+
+func (f *fakeNAT) String() string {
+	return "fake"
+}
+
+func testListener(t *testing.T, gateway nat.NAT, extPort int) *DefaultListener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &DefaultListener{
+		listener:    listener,
+		connections: make(chan net.Conn, numBufferedConnections),
+		gateway:     gateway,
+		intPort:     46656,
+		extPort:     extPort,
+	}
+	l.BaseService = *cmn.NewBaseService(nil, "DefaultListener", l)
+	return l
+}
+
+func TestListenerStopDeletesMapping(t *testing.T) {
+	gateway := &fakeNAT{mapTo: []int{46657}}
+	l := testListener(t, gateway, 46657)
+	if _, err := l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	l.Stop()
+
+	if len(gateway.deleted) != 1 || gateway.deleted[0] != 46657 {
+		t.Errorf("expected the mapping of port 46657 to be deleted, got %v", gateway.deleted)
+	}
+	if _, ok := <-l.Connections(); ok {
+		t.Error("expected the connections channel to be closed")
+	}
+}
+
+func TestListenerRenewPortMapping(t *testing.T) {
+	cases := []struct {
+		mapTo   []int
+		added   []int
+		deleted []int
+	}{
+		{mapTo: []int{46657}, added: []int{46657}},
+		// the gateway gives the advertised port back on the second request
+		{mapTo: []int{50000, 46657}, added: []int{46657, 46657}, deleted: []int{50000}},
+		// it keeps another port, no mapping is left behind
+		{mapTo: []int{50000, 50001}, added: []int{46657, 46657}, deleted: []int{50000, 50001}},
+	}
+
+	for i, c := range cases {
+		gateway := &fakeNAT{mapTo: c.mapTo}
+		l := testListener(t, gateway, 46657)
+		l.renewPortMapping()
+		l.listener.Close()
+
+		if !equalPorts(gateway.added, c.added) || !equalPorts(gateway.deleted, c.deleted) {
+			t.Errorf("case %d: expected added %v deleted %v, got added %v deleted %v", i, c.added, c.deleted, gateway.added, gateway.deleted)
+		}
+		if l.extPort != 46657 {
+			t.Errorf("case %d: advertised port changed to %d", i, l.extPort)
+		}
+	}
+}
+
+func TestListenerMapPortWithoutExternalIP(t *testing.T) {
+	gateway := &fakeNAT{mapTo: []int{46656}, ipErr: errors.New("no ip")}
+	l := testListener(t, nil, 0)
+	defer l.listener.Close()
+
+	if addr := l.mapGateway(gateway); addr != nil {
+		t.Errorf("expected no external address, got %v", addr)
+	}
+	if l.gateway != nil {
+		t.Error("expected the gateway to be forgotten")
+	}
+	if len(gateway.deleted) != 1 || gateway.deleted[0] != 46656 {
+		t.Errorf("expected the mapping to be deleted, got %v", gateway.deleted)
+	}
+}
+
+func equalPorts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
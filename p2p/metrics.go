@@ -0,0 +1,112 @@
+package p2p
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "nodestats"
+
+var (
+	dialAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "p2p",
+		Name:      "dial_attempts_total",
+		Help:      "Number of outbound dial attempts.",
+	})
+	dialSuccesses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "p2p",
+		Name:      "dial_successes_total",
+		Help:      "Number of outbound dials that ended with an added peer.",
+	})
+	dialFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "p2p",
+		Name:      "dial_failures_total",
+		Help:      "Number of failed outbound dials by error class.",
+	}, []string{"class"})
+	handshakeLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "p2p",
+		Name:      "handshake_duration_seconds",
+		Help:      "Duration of the NodeInfo handshake with a peer.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+)
+
+func init() {
+	prometheus.MustRegister(dialAttempts, dialSuccesses, dialFailures, handshakeLatency)
+}
+
+// DialErrorClass maps a DialPeerWithAddress error to a low cardinality label
+func DialErrorClass(err error) string {
+	cause := errors.Cause(err)
+	switch cause {
+	case ErrDuplicatePeer:
+		return "duplicate"
+	case ErrDuplicatePeerIP:
+		return "duplicate_ip"
+	case ErrConnectSelf:
+		return "self"
+	case ErrConnectBannedPeer:
+		return "banned"
+	case ErrMaxPeers, ErrMaxPeersInGroup:
+		return "limit"
+	}
+
+	if _, ok := err.(*IncompatiblePeerError); ok {
+		return "incompatible"
+	}
+	if nerr, ok := cause.(net.Error); ok && nerr.Timeout() {
+		return "timeout"
+	}
+	if _, ok := cause.(*net.OpError); ok {
+		return "connect"
+	}
+	return "handshake"
+}
+
+// switchCollector reports the gauges that are read from the switch state on scrape
+type switchCollector struct {
+	sw           *Switch
+	peersDesc    *prometheus.Desc
+	addrBookDesc *prometheus.Desc
+}
+
+// NewSwitchCollector returns a prometheus collector for the switch peers and address book
+func NewSwitchCollector(sw *Switch) prometheus.Collector {
+	return &switchCollector{
+		sw: sw,
+		peersDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "p2p", "peers"),
+			"Number of connected and dialing peers by direction.",
+			[]string{"direction"}, nil,
+		),
+		addrBookDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "p2p", "addrbook_size"),
+			"Number of addresses in the address book by bucket type.",
+			[]string{"bucket"}, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *switchCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.peersDesc
+	ch <- c.addrBookDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *switchCollector) Collect(ch chan<- prometheus.Metric) {
+	outbound, inbound, dialing := c.sw.NumPeers()
+	ch <- prometheus.MustNewConstMetric(c.peersDesc, prometheus.GaugeValue, float64(outbound), "outbound")
+	ch <- prometheus.MustNewConstMetric(c.peersDesc, prometheus.GaugeValue, float64(inbound), "inbound")
+	ch <- prometheus.MustNewConstMetric(c.peersDesc, prometheus.GaugeValue, float64(dialing), "dialing")
+
+	nNew, nOld := c.sw.addrBook.Sizes()
+	ch <- prometheus.MustNewConstMetric(c.addrBookDesc, prometheus.GaugeValue, float64(nNew), "new")
+	ch <- prometheus.MustNewConstMetric(c.addrBookDesc, prometheus.GaugeValue, float64(nOld), "old")
+}
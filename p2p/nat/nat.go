@@ -0,0 +1,59 @@
+// Package nat maps the p2p listen port on the local gateway with UPnP IGD or
+// NAT-PMP and learns the external IP address.
+package nat
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultTimeout = 3 * time.Second
+
+// ErrNoGateway is returned when neither UPnP nor NAT-PMP answered
+var ErrNoGateway = errors.New("no UPnP or NAT-PMP gateway found")
+
+// NAT is a gateway able to forward a port to us
+type NAT interface {
+	// ExternalIP returns the public address of the gateway
+	ExternalIP() (net.IP, error)
+	// AddPortMapping forwards extPort to intPort and returns the external
+	// port actually mapped, which the gateway may pick differently
+	AddPortMapping(protocol string, extPort, intPort int, desc string, lifetime time.Duration) (int, error)
+	// DeletePortMapping removes a mapping added by AddPortMapping
+	DeletePortMapping(protocol string, extPort, intPort int) error
+	String() string
+}
+
+// Options locates the gateways, the zero value uses the standard discovery.
+// Tests point the addresses at local fake responders.
+type Options struct {
+	SSDPAddr   string        // UPnP search address, defaults to 239.255.255.250:1900
+	PMPGateway string        // NAT-PMP gateway host:port, defaults to the default route gateway on port 5351
+	Timeout    time.Duration // per protocol discovery timeout
+}
+
+func (o *Options) timeout() time.Duration {
+	if o.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return o.Timeout
+}
+
+// Discover returns the first gateway answering, UPnP IGD is tried before NAT-PMP
+func Discover(opts *Options) (NAT, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	nat, upnpErr := discoverUPnP(opts)
+	if upnpErr == nil {
+		return nat, nil
+	}
+	nat, pmpErr := discoverPMP(opts)
+	if pmpErr == nil {
+		return nat, nil
+	}
+	return nil, errors.Wrapf(ErrNoGateway, "upnp: %v, nat-pmp: %v", upnpErr, pmpErr)
+}
@@ -0,0 +1,148 @@
+package nat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackpal/gateway"
+	"github.com/pkg/errors"
+)
+
+// NAT-PMP, RFC 6886
+const (
+	pmpPort            = 5351
+	pmpVersion         = 0
+	pmpOpExternalIP    = 0
+	pmpOpMapUDP        = 1
+	pmpOpMapTCP        = 2
+	pmpResponseOpFlag  = 128
+	pmpInitialInterval = 250 * time.Millisecond
+)
+
+var pmpResultCodes = map[uint16]string{
+	1: "unsupported version",
+	2: "not authorized or refused",
+	3: "network failure",
+	4: "out of resources",
+	5: "unsupported opcode",
+}
+
+// pmpNAT is a gateway speaking NAT-PMP over UDP
+type pmpNAT struct {
+	gateway string
+	timeout time.Duration
+}
+
+// discoverPMP asks the gateway for its external address to check it speaks NAT-PMP
+func discoverPMP(opts *Options) (NAT, error) {
+	gw := opts.PMPGateway
+	if gw == "" {
+		ip, err := gateway.DiscoverGateway()
+		if err != nil {
+			return nil, errors.Wrap(err, "discover default gateway")
+		}
+		gw = net.JoinHostPort(ip.String(), strconv.Itoa(pmpPort))
+	}
+
+	nat := &pmpNAT{gateway: gw, timeout: opts.timeout()}
+	if _, err := nat.ExternalIP(); err != nil {
+		return nil, err
+	}
+	return nat, nil
+}
+
+// call sends the request and waits for the matching response, resending
+// with a doubling interval as the RFC asks until the timeout.
+func (p *pmpNAT) call(req []byte, respSize int) ([]byte, error) {
+	conn, err := net.Dial("udp", p.gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(p.timeout)
+	buf := make([]byte, 16)
+	for interval := pmpInitialInterval; time.Now().Before(deadline); interval *= 2 {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+
+		readDeadline := time.Now().Add(interval)
+		if readDeadline.After(deadline) {
+			readDeadline = deadline
+		}
+		conn.SetReadDeadline(readDeadline)
+		n, err := conn.Read(buf)
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+		if n < respSize || buf[0] != pmpVersion || buf[1] != req[1]|pmpResponseOpFlag {
+			continue
+		}
+		if code := binary.BigEndian.Uint16(buf[2:4]); code != 0 {
+			return nil, fmt.Errorf("nat-pmp: %s (code %d)", pmpResultCodes[code], code)
+		}
+		return buf[:n], nil
+	}
+	return nil, errors.New("nat-pmp: gateway didn't answer")
+}
+
+func (p *pmpNAT) ExternalIP() (net.IP, error) {
+	resp, err := p.call([]byte{pmpVersion, pmpOpExternalIP}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+func (p *pmpNAT) AddPortMapping(protocol string, extPort, intPort int, desc string, lifetime time.Duration) (int, error) {
+	op, err := pmpMapOp(protocol)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := p.call(pmpMapRequest(op, extPort, intPort, lifetime), 16)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(resp[10:12])), nil
+}
+
+// DeletePortMapping is a mapping request with a zero lifetime and external port
+func (p *pmpNAT) DeletePortMapping(protocol string, extPort, intPort int) error {
+	op, err := pmpMapOp(protocol)
+	if err != nil {
+		return err
+	}
+	_, err = p.call(pmpMapRequest(op, 0, intPort, 0), 16)
+	return err
+}
+
+func (p *pmpNAT) String() string {
+	return "NAT-PMP(" + p.gateway + ")"
+}
+
+func pmpMapOp(protocol string) (byte, error) {
+	switch strings.ToLower(protocol) {
+	case "tcp":
+		return pmpOpMapTCP, nil
+	case "udp":
+		return pmpOpMapUDP, nil
+	}
+	return 0, fmt.Errorf("nat-pmp: unsupported protocol %v", protocol)
+}
+
+func pmpMapRequest(op byte, extPort, intPort int, lifetime time.Duration) []byte {
+	req := make([]byte, 12)
+	req[0], req[1] = pmpVersion, op
+	binary.BigEndian.PutUint16(req[4:6], uint16(intPort))
+	binary.BigEndian.PutUint16(req[6:8], uint16(extPort))
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	return req
+}
@@ -0,0 +1,175 @@
+package nat
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePMP is a NAT-PMP gateway on the loopback interface
+type fakePMP struct {
+	conn net.PacketConn
+
+	mtx      sync.Mutex
+	drop     int    // number of requests left unanswered before replying
+	code     uint16 // result code of the replies
+	mapTo    uint16 // external port of the mapping replies
+	requests [][]byte
+}
+
+func newFakePMP(t *testing.T, drop int) *fakePMP {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakePMP{conn: conn, drop: drop, mapTo: 50000}
+	go p.serve()
+	return p
+}
+
+func (p *fakePMP) serve() {
+	buf := make([]byte, 16)
+	for {
+		n, addr, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := append([]byte{}, buf[:n]...)
+
+		p.mtx.Lock()
+		p.requests = append(p.requests, req)
+		drop := p.drop > 0
+		if drop {
+			p.drop--
+		}
+		code, mapTo := p.code, p.mapTo
+		p.mtx.Unlock()
+		if drop {
+			continue
+		}
+
+		var resp []byte
+		if req[1] == pmpOpExternalIP {
+			resp = make([]byte, 12)
+			copy(resp[8:12], net.IPv4(203, 0, 113, 9).To4())
+		} else {
+			resp = make([]byte, 16)
+			copy(resp[8:10], req[4:6])
+			binary.BigEndian.PutUint16(resp[10:12], mapTo)
+			copy(resp[12:16], req[8:12])
+		}
+		resp[0], resp[1] = pmpVersion, req[1]|pmpResponseOpFlag
+		binary.BigEndian.PutUint16(resp[2:4], code)
+		p.conn.WriteTo(resp, addr)
+	}
+}
+
+func (p *fakePMP) nat(timeout time.Duration) *pmpNAT {
+	return &pmpNAT{gateway: p.conn.LocalAddr().String(), timeout: timeout}
+}
+
+func (p *fakePMP) numRequests() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return len(p.requests)
+}
+
+func TestPMPExternalIPRetry(t *testing.T) {
+	p := newFakePMP(t, 1)
+	defer p.conn.Close()
+
+	ip, err := p.nat(2 * time.Second).ExternalIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.IPv4(203, 0, 113, 9)) {
+		t.Errorf("unexpected external ip %v", ip)
+	}
+	if n := p.numRequests(); n != 2 {
+		t.Errorf("expected the request to be sent again once, got %d requests", n)
+	}
+}
+
+func TestPMPTimeout(t *testing.T) {
+	p := newFakePMP(t, 100)
+	defer p.conn.Close()
+
+	_, err := p.nat(600 * time.Millisecond).ExternalIP()
+	if err == nil || !strings.Contains(err.Error(), "didn't answer") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	// sent at 0 and 250ms, the next one would be due after the timeout
+	if n := p.numRequests(); n != 2 {
+		t.Errorf("expected 2 requests with a doubling interval, got %d", n)
+	}
+}
+
+func TestPMPResultCodes(t *testing.T) {
+	p := newFakePMP(t, 0)
+	defer p.conn.Close()
+
+	for code, desc := range pmpResultCodes {
+		p.mtx.Lock()
+		p.code = code
+		p.mtx.Unlock()
+
+		_, err := p.nat(time.Second).AddPortMapping("tcp", 46656, 46656, "nodestats", time.Minute)
+		if err == nil || !strings.Contains(err.Error(), desc) {
+			t.Errorf("code %d: expected %q, got %v", code, desc, err)
+		}
+	}
+}
+
+func TestPMPPortMapping(t *testing.T) {
+	p := newFakePMP(t, 0)
+	defer p.conn.Close()
+	gateway := p.nat(time.Second)
+
+	port, err := gateway.AddPortMapping("tcp", 46656, 46657, "nodestats", 20*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 50000 {
+		t.Errorf("expected the port picked by the gateway, got %d", port)
+	}
+	if err := gateway.DeletePortMapping("tcp", port, 46657); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gateway.AddPortMapping("sctp", 46656, 46657, "nodestats", time.Minute); err == nil {
+		t.Error("expected an unsupported protocol error")
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if len(p.requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(p.requests))
+	}
+	add, del := p.requests[0], p.requests[1]
+	if add[1] != pmpOpMapTCP || binary.BigEndian.Uint16(add[4:6]) != 46657 || binary.BigEndian.Uint16(add[6:8]) != 46656 || binary.BigEndian.Uint32(add[8:12]) != 1200 {
+		t.Errorf("unexpected mapping request %v", add)
+	}
+	if del[1] != pmpOpMapTCP || binary.BigEndian.Uint16(del[4:6]) != 46657 || binary.BigEndian.Uint16(del[6:8]) != 0 || binary.BigEndian.Uint32(del[8:12]) != 0 {
+		t.Errorf("unexpected delete request %v", del)
+	}
+}
+
+func TestDiscoverFallsBackToPMP(t *testing.T) {
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	p := newFakePMP(t, 0)
+	defer p.conn.Close()
+
+	gateway, err := Discover(&Options{SSDPAddr: silent.LocalAddr().String(), PMPGateway: p.conn.LocalAddr().String(), Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gateway.(*pmpNAT); !ok {
+		t.Errorf("expected a NAT-PMP gateway, got %v", gateway)
+	}
+}
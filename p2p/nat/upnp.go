@@ -0,0 +1,254 @@
+package nat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSSDPAddr  = "239.255.255.250:1900"
+	igdDeviceType    = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	wanIPService     = "urn:schemas-upnp-org:service:WANIPConnection:"
+	wanPPPService    = "urn:schemas-upnp-org:service:WANPPPConnection:"
+	soapEnvelopeHead = `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`
+	soapEnvelopeTail = `</s:Body></s:Envelope>`
+)
+
+// upnpNAT is an Internet Gateway Device controlled over SOAP
+type upnpNAT struct {
+	controlURL  string
+	serviceType string
+	localIP     net.IP
+	client      *http.Client
+}
+
+type upnpRoot struct {
+	Device upnpDevice `xml:"device"`
+}
+
+type upnpDevice struct {
+	DeviceType string        `xml:"deviceType"`
+	Devices    []upnpDevice  `xml:"deviceList>device"`
+	Services   []upnpService `xml:"serviceList>service"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type soapResponse struct {
+	Body struct {
+		ExternalIP string     `xml:"GetExternalIPAddressResponse>NewExternalIPAddress"`
+		Fault      *soapFault `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+type soapFault struct {
+	FaultString string `xml:"faultstring"`
+	ErrorCode   string `xml:"detail>UPnPError>errorCode"`
+	ErrorDesc   string `xml:"detail>UPnPError>errorDescription"`
+}
+
+// discoverUPnP searches the gateway with SSDP and reads its device description
+func discoverUPnP(opts *Options) (NAT, error) {
+	ssdpAddr := opts.SSDPAddr
+	if ssdpAddr == "" {
+		ssdpAddr = defaultSSDPAddr
+	}
+	location, err := ssdpSearch(ssdpAddr, opts.timeout())
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: opts.timeout()}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch upnp device description")
+	}
+	defer resp.Body.Close()
+
+	root := &upnpRoot{}
+	if err := xml.NewDecoder(resp.Body).Decode(root); err != nil {
+		return nil, errors.Wrap(err, "decode upnp device description")
+	}
+	service := findWANService(&root.Device)
+	if service == nil {
+		return nil, errors.New("upnp device has no WAN connection service")
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	controlURL, err := base.Parse(service.ControlURL)
+	if err != nil {
+		return nil, err
+	}
+	localIP, err := localIPTowards(base.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upnpNAT{
+		controlURL:  controlURL.String(),
+		serviceType: service.ServiceType,
+		localIP:     localIP,
+		client:      client,
+	}, nil
+}
+
+// ssdpSearch sends an M-SEARCH for gateways and returns the first description location
+func ssdpSearch(ssdpAddr string, timeout time.Duration) (string, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"ST: " + igdDeviceType + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(search), raddr); err != nil {
+		return "", err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1536)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", errors.Wrap(err, "ssdp search")
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		if !strings.Contains(resp.Header.Get("St"), "InternetGatewayDevice") {
+			continue
+		}
+		if location := resp.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+func findWANService(device *upnpDevice) *upnpService {
+	for i := range device.Services {
+		st := device.Services[i].ServiceType
+		if strings.HasPrefix(st, wanIPService) || strings.HasPrefix(st, wanPPPService) {
+			return &device.Services[i]
+		}
+	}
+	for i := range device.Devices {
+		if service := findWANService(&device.Devices[i]); service != nil {
+			return service
+		}
+	}
+	return nil
+}
+
+// localIPTowards returns the local address used to reach host, no packet is sent
+func localIPTowards(host string) (net.IP, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+	conn, err := net.Dial("udp4", host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func (u *upnpNAT) soapRequest(action, args string) (*soapResponse, error) {
+	body := soapEnvelopeHead +
+		"<u:" + action + " xmlns:u=\"" + u.serviceType + "\">" + args + "</u:" + action + ">" +
+		soapEnvelopeTail
+
+	req, err := http.NewRequest("POST", u.controlURL, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+u.serviceType+"#"+action+`"`)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "upnp %s", action)
+	}
+	defer resp.Body.Close()
+
+	result := &soapResponse{}
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, errors.Wrapf(err, "decode upnp %s response", action)
+	}
+	if fault := result.Body.Fault; fault != nil {
+		return nil, fmt.Errorf("upnp %s: %s %s %s", action, fault.FaultString, fault.ErrorCode, fault.ErrorDesc)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upnp %s: %s", action, resp.Status)
+	}
+	return result, nil
+}
+
+func soapArg(name, value string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(value))
+	return "<" + name + ">" + buf.String() + "</" + name + ">"
+}
+
+func (u *upnpNAT) ExternalIP() (net.IP, error) {
+	resp, err := u.soapRequest("GetExternalIPAddress", "")
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(resp.Body.ExternalIP))
+	if ip == nil {
+		return nil, fmt.Errorf("upnp gateway returned an invalid external IP %q", resp.Body.ExternalIP)
+	}
+	return ip, nil
+}
+
+func (u *upnpNAT) AddPortMapping(protocol string, extPort, intPort int, desc string, lifetime time.Duration) (int, error) {
+	args := soapArg("NewRemoteHost", "") +
+		soapArg("NewExternalPort", strconv.Itoa(extPort)) +
+		soapArg("NewProtocol", strings.ToUpper(protocol)) +
+		soapArg("NewInternalPort", strconv.Itoa(intPort)) +
+		soapArg("NewInternalClient", u.localIP.String()) +
+		soapArg("NewEnabled", "1") +
+		soapArg("NewPortMappingDescription", desc) +
+		soapArg("NewLeaseDuration", strconv.Itoa(int(lifetime/time.Second)))
+	if _, err := u.soapRequest("AddPortMapping", args); err != nil {
+		return 0, err
+	}
+	return extPort, nil
+}
+
+func (u *upnpNAT) DeletePortMapping(protocol string, extPort, intPort int) error {
+	args := soapArg("NewRemoteHost", "") +
+		soapArg("NewExternalPort", strconv.Itoa(extPort)) +
+		soapArg("NewProtocol", strings.ToUpper(protocol))
+	_, err := u.soapRequest("DeletePortMapping", args)
+	return err
+}
+
+func (u *upnpNAT) String() string {
+	return "UPnP(" + u.controlURL + ")"
+}
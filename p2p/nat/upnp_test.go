@@ -0,0 +1,200 @@
+package nat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+const testFault = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>
+<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>
+<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>718</errorCode><errorDescription>ConflictInMappingEntry</errorDescription></UPnPError></detail>
+</s:Fault></s:Body></s:Envelope>`
+
+// fakeIGD is an Internet Gateway Device answering SSDP searches and SOAP
+// requests on the loopback interface.
+type fakeIGD struct {
+	ssdp   net.PacketConn
+	server *httptest.Server
+
+	mtx     sync.Mutex
+	actions []string
+	bodies  []string
+	fault   bool
+}
+
+func newFakeIGD(t *testing.T) *fakeIGD {
+	igd := &fakeIGD{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/desc.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testDescription)
+	})
+	mux.HandleFunc("/ctl/IPConn", igd.serveSOAP)
+	igd.server = httptest.NewServer(mux)
+
+	ssdp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	igd.ssdp = ssdp
+	go igd.serveSSDP()
+	return igd
+}
+
+func (igd *fakeIGD) close() {
+	igd.ssdp.Close()
+	igd.server.Close()
+}
+
+// serveSSDP answers every search with a root device reply, which must be
+// skipped, then the gateway reply.
+func (igd *fakeIGD) serveSSDP() {
+	buf := make([]byte, 1536)
+	for {
+		n, addr, err := igd.ssdp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if !strings.HasPrefix(string(buf[:n]), "M-SEARCH * HTTP/1.1") {
+			continue
+		}
+		igd.ssdp.WriteTo([]byte("HTTP/1.1 200 OK\r\nST: upnp:rootdevice\r\nLOCATION: http://127.0.0.1:1/other.xml\r\n\r\n"), addr)
+		igd.ssdp.WriteTo([]byte("HTTP/1.1 200 OK\r\nST: "+igdDeviceType+"\r\nLOCATION: "+igd.server.URL+"/desc.xml\r\n\r\n"), addr)
+	}
+}
+
+func (igd *fakeIGD) serveSOAP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	action := r.Header.Get("SOAPAction")
+	action = strings.Trim(action[strings.Index(action, "#")+1:], `"`)
+
+	igd.mtx.Lock()
+	igd.actions = append(igd.actions, action)
+	igd.bodies = append(igd.bodies, string(body))
+	fault := igd.fault
+	igd.mtx.Unlock()
+
+	if fault {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, testFault)
+		return
+	}
+	fmt.Fprint(w, soapEnvelopeHead)
+	if action == "GetExternalIPAddress" {
+		fmt.Fprint(w, `<u:GetExternalIPAddressResponse xmlns:u="`+wanIPService+`1"><NewExternalIPAddress>203.0.113.7</NewExternalIPAddress></u:GetExternalIPAddressResponse>`)
+	} else {
+		fmt.Fprint(w, `<u:`+action+`Response xmlns:u="`+wanIPService+`1"></u:`+action+`Response>`)
+	}
+	fmt.Fprint(w, soapEnvelopeTail)
+}
+
+func TestDiscoverUPnP(t *testing.T) {
+	igd := newFakeIGD(t)
+	defer igd.close()
+
+	gateway, err := discoverUPnP(&Options{SSDPAddr: igd.ssdp.LocalAddr().String(), Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := gateway.(*upnpNAT)
+	if u.controlURL != igd.server.URL+"/ctl/IPConn" {
+		t.Errorf("unexpected control url %s", u.controlURL)
+	}
+	if u.serviceType != wanIPService+"1" {
+		t.Errorf("unexpected service type %s", u.serviceType)
+	}
+	if !u.localIP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("unexpected local ip %v", u.localIP)
+	}
+
+	ip, err := gateway.ExternalIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.IPv4(203, 0, 113, 7)) {
+		t.Errorf("unexpected external ip %v", ip)
+	}
+
+	port, err := gateway.AddPortMapping("tcp", 46656, 46656, "nodestats", 20*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 46656 {
+		t.Errorf("expected the requested port, got %d", port)
+	}
+	if err := gateway.DeletePortMapping("tcp", 46656, 46656); err != nil {
+		t.Fatal(err)
+	}
+
+	igd.mtx.Lock()
+	defer igd.mtx.Unlock()
+	expected := []string{"GetExternalIPAddress", "AddPortMapping", "DeletePortMapping"}
+	if strings.Join(igd.actions, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected actions %v, got %v", expected, igd.actions)
+	}
+	for _, arg := range []string{"<NewExternalPort>46656</NewExternalPort>", "<NewProtocol>TCP</NewProtocol>", "<NewInternalClient>127.0.0.1</NewInternalClient>", "<NewLeaseDuration>1200</NewLeaseDuration>"} {
+		if !strings.Contains(igd.bodies[1], arg) {
+			t.Errorf("AddPortMapping request misses %s", arg)
+		}
+	}
+}
+
+func TestUPnPFault(t *testing.T) {
+	igd := newFakeIGD(t)
+	defer igd.close()
+
+	gateway, err := discoverUPnP(&Options{SSDPAddr: igd.ssdp.LocalAddr().String(), Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	igd.mtx.Lock()
+	igd.fault = true
+	igd.mtx.Unlock()
+
+	_, err = gateway.AddPortMapping("tcp", 46656, 46656, "nodestats", 20*time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "718 ConflictInMappingEntry") {
+		t.Errorf("expected the UPnP error, got %v", err)
+	}
+}
+
+func TestDiscoverUPnPTimeout(t *testing.T) {
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	if _, err := discoverUPnP(&Options{SSDPAddr: silent.LocalAddr().String(), Timeout: 100 * time.Millisecond}); err == nil {
+		t.Error("expected the search to time out")
+	}
+}
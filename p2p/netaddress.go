@@ -84,6 +84,34 @@ func NewNetAddressStrings(addrs []string) ([]*NetAddress, error) {
 	return netAddrs, nil
 }
 
+// ResolveNetAddresses returns one NetAddress per IP the host of addr resolves
+// to. Unlike NewNetAddressString every A/AAAA record is kept, so it should be
+// called again whenever a fresh view of the DNS is needed.
+func ResolveNetAddresses(addr string) ([]*NetAddress, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return []*NetAddress{NewNetAddressIPPort(ip, uint16(port))}, nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+
+	netAddrs := make([]*NetAddress, 0, len(ips))
+	for _, ip := range ips {
+		netAddrs = append(netAddrs, NewNetAddressIPPort(ip, uint16(port)))
+	}
+	return netAddrs, nil
+}
+
 // NewNetAddressIPPort returns a new NetAddress using the provided IP
 // and port number.
 func NewNetAddressIPPort(ip net.IP, port uint16) *NetAddress {
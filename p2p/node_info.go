@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"net"
 	"strconv"
-	"strings"
 
 	crypto "github.com/tendermint/go-crypto"
 )
@@ -23,11 +22,11 @@ type NodeInfo struct {
 }
 
 // CompatibleWith checks if two NodeInfo are compatible with eachother.
-// CONTRACT: two nodes are compatible if the major version matches and network match
-// and they have at least one channel in common.
-func (info *NodeInfo) CompatibleWith(other *NodeInfo) error {
-	iMajor, iMinor, _, iErr := splitVersion(info.Version)
-	oMajor, oMinor, _, oErr := splitVersion(other.Version)
+// CONTRACT: two nodes are compatible if the version policy accepts the peer
+// version and the networks match.
+func (info *NodeInfo) CompatibleWith(other *NodeInfo, policy VersionPolicy) error {
+	iVersion, iErr := info.SemVer()
+	oVersion, oErr := other.SemVer()
 
 	// if our own version number is not formatted right, we messed up
 	if iErr != nil {
@@ -39,14 +38,8 @@ func (info *NodeInfo) CompatibleWith(other *NodeInfo) error {
 		return oErr
 	}
 
-	// major version must match
-	if iMajor != oMajor {
-		return fmt.Errorf("Peer is on a different major version. Got %v, expected %v", oMajor, iMajor)
-	}
-
-	// minor version must match
-	if iMinor != oMinor {
-		return fmt.Errorf("Peer is on a different minor version. Got %v, expected %v", oMinor, iMinor)
+	if err := policy.Compatible(iVersion, oVersion); err != nil {
+		return err
 	}
 
 	// nodes must be on the same network
@@ -84,10 +77,7 @@ func (info NodeInfo) String() string {
 	return fmt.Sprintf("NodeInfo{pk: %v, moniker: %v, network: %v [listen %v], version: %v (%v)}", info.PubKey, info.Moniker, info.Network, info.ListenAddr, info.Version, info.Other)
 }
 
-func splitVersion(version string) (string, string, string, error) {
-	spl := strings.Split(version, ".")
-	if len(spl) != 3 {
-		return "", "", "", fmt.Errorf("Invalid version format %v", version)
-	}
-	return spl[0], spl[1], spl[2], nil
+//SemVer parses the announced version
+func (info *NodeInfo) SemVer() (*Version, error) {
+	return ParseVersion(info.Version)
 }
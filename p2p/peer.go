@@ -21,6 +21,15 @@ type peerConn struct {
 	conn     net.Conn // source connection
 }
 
+// remoteAddr returns the address of the remote end, nil if it can't be parsed.
+func (pc *peerConn) remoteAddr() *NetAddress {
+	addr, err := NewNetAddressString(pc.conn.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+	return addr
+}
+
 // PeerConfig is a Peer configuration.
 type PeerConfig struct {
 	HandshakeTimeout time.Duration           `mapstructure:"handshake_timeout"` // times are in seconds
@@ -30,10 +39,17 @@ type PeerConfig struct {
 
 // DefaultPeerConfig returns the default config.
 func DefaultPeerConfig(config *cfg.P2PConfig) *PeerConfig {
+	mconfig := connection.DefaultMConnConfig()
+	if config.PingInterval > 0 {
+		mconfig.PingInterval = time.Duration(config.PingInterval) * time.Second
+	}
+	if config.PongTimeout > 0 {
+		mconfig.PongTimeout = time.Duration(config.PongTimeout) * time.Second
+	}
 	return &PeerConfig{
 		HandshakeTimeout: time.Duration(config.HandshakeTimeout), // * time.Second,
 		DialTimeout:      time.Duration(config.DialTimeout),      // * time.Second,
-		MConfig:          connection.DefaultMConnConfig(),
+		MConfig:          mconfig,
 	}
 }
 
@@ -44,7 +60,8 @@ type Peer struct {
 	mconn *connection.MConnection // multiplex connection
 
 	*NodeInfo
-	Key  string
+	Key         string
+	connectedAt time.Time
 	//Data *cmn.CMap // User data.
 }
 
@@ -55,6 +72,21 @@ func (p *Peer) Start() error {
 }
 
 
+// IsOutbound returns true if the connection is outbound, false otherwise.
+func (p *Peer) IsOutbound() bool {
+	return p.outbound
+}
+
+// ConnectedAt returns the time the peer finished the handshake
+func (p *Peer) ConnectedAt() time.Time {
+	return p.connectedAt
+}
+
+// Status returns the status of the peer's multiplex connection
+func (p *Peer) Status() connection.ConnectionStatus {
+	return p.mconn.Status()
+}
+
 // OnStop implements BaseService.
 func (p *Peer) Stop() {
 	//p.BaseService.OnStop()
@@ -65,8 +97,9 @@ func newPeer(pc *peerConn, nodeInfo *NodeInfo, reactorsByCh map[byte]Reactor, ch
 	// Key and NodeInfo are set after Handshake
 	p := &Peer{
 		peerConn: pc,
-		NodeInfo: nodeInfo,
-		Key:      nodeInfo.PubKey.KeyString(),
+		NodeInfo:    nodeInfo,
+		Key:         nodeInfo.PubKey.KeyString(),
+		connectedAt: time.Now(),
 	}
 	p.mconn = createMConnection(pc.conn, p, reactorsByCh, chDescs, onPeerError, pc.config.MConfig)
 	//p.BaseService = *cmn.NewBaseService(nil, "Peer", p)
@@ -101,6 +134,10 @@ func newOutboundPeerConn(addr *NetAddress, ourNodePrivKey crypto.PrivKeyEd25519,
 	return pc, nil
 }
 
+func newInboundPeerConn(conn net.Conn, ourNodePrivKey crypto.PrivKeyEd25519, config *PeerConfig) (*peerConn, error) {
+	return newPeerConn(conn, false, ourNodePrivKey, config)
+}
+
 func dial(addr *NetAddress, config *PeerConfig) (net.Conn, error) {
 	conn, err := addr.DialTimeout(config.DialTimeout * time.Second)
 	if err != nil {
@@ -15,13 +15,22 @@ type peerSetItem struct {
 	index int
 }
 
+// NewPeerSet creates a new peerSet with a list of initial capacity of 256 items.
+func NewPeerSet() *PeerSet {
+	return &PeerSet{
+		lookup: make(map[string]*peerSetItem),
+		list:   make([]*Peer, 0, 256),
+	}
+}
+
 // Remove discards peer if the peer was previously memoized.
-func (ps *PeerSet) Remove(peer *Peer) {
+// Returns false if the peer was not in the set.
+func (ps *PeerSet) Remove(peer *Peer) bool {
 	ps.mtx.Lock()
 	defer ps.mtx.Unlock()
 	item := ps.lookup[peer.Key]
 	if item == nil {
-		return
+		return false
 	}
 
 	index := item.index
@@ -33,7 +42,7 @@ func (ps *PeerSet) Remove(peer *Peer) {
 	if index == len(ps.list)-1 {
 		ps.list = newList
 		delete(ps.lookup, peer.Key)
-		return
+		return true
 	}
 
 	// Move the last item from ps.list to "index" in list.
@@ -44,6 +53,7 @@ func (ps *PeerSet) Remove(peer *Peer) {
 	lastPeerItem.index = index
 	ps.list = newList
 	delete(ps.lookup, peer.Key)
+	return true
 }
 
 // Add adds the peer to the PeerSet.
@@ -61,6 +71,27 @@ func (ps *PeerSet) Add(peer *Peer) error {
 	return nil
 }
 
+// Has returns true if the peer with the given key is in the set
+func (ps *PeerSet) Has(peerKey string) bool {
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	_, ok := ps.lookup[peerKey]
+	return ok
+}
+
+// HasIP returns true if a peer connected from or to the given IP is in the set
+func (ps *PeerSet) HasIP(ip string) bool {
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+
+	for _, peer := range ps.list {
+		if peer.RemoteAddrHost() == ip {
+			return true
+		}
+	}
+	return false
+}
+
 // Size returns the number of unique items in the peerSet.
 func (ps *PeerSet) Size() int {
 	ps.mtx.Lock()
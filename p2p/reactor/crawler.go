@@ -0,0 +1,190 @@
+package reactor
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nodestats/p2p"
+)
+
+const (
+	crawlTickPeriod = 30 * time.Second
+	crawlPexTimeout = 30 * time.Second
+	crawlSlotPeriod = time.Second // polling period while waiting for an outbound slot
+)
+
+// crawlPeer is an outbound peer that was dialed by the crawler and is waiting
+// for its address response before it gets disconnected.
+type crawlPeer struct {
+	peer        *p2p.Peer
+	requestedAt time.Time
+}
+
+// crawlMode is always on for seeds, it keeps the book they hand out fresh
+func (r *PEXReactor) crawlMode() bool {
+	return r.Switch.Config.CrawlMode || r.seedMode()
+}
+
+// crawlRoutine sweeps the whole address book instead of maintaining a handful
+// of outbound peers, every known address gets revisited once per crawl interval.
+// The seeds are resolved on every round so the IPs they rotate to get into the
+// book and are crawled like any other address.
+func (r *PEXReactor) crawlRoutine() {
+	r.addSeeds()
+	r.crawl()
+
+	ticker := time.NewTicker(crawlTickPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.expireCrawlPeers()
+			r.addSeeds()
+			r.crawl()
+		case <-r.Quit:
+			return
+		}
+	}
+}
+
+// crawl dials every address that hasn't been visited within the crawl interval.
+func (r *PEXReactor) crawl() {
+	interval := time.Duration(r.Switch.Config.CrawlInterval) * time.Second
+	connectedPeers := make(map[string]struct{})
+	for _, peer := range r.Switch.Peers().List() {
+		connectedPeers[peer.RemoteAddrHost()] = struct{}{}
+	}
+
+	now := time.Now()
+	r.pruneCrawledAt(now, interval)
+	toDial := []*p2p.NetAddress{}
+	for _, addr := range r.book.GetAddressList() {
+		if last, ok := r.crawledAt.Get(addr.String()).(time.Time); ok && now.Sub(last) < interval {
+			continue
+		}
+		if _, ok := connectedPeers[addr.IP.String()]; ok {
+			continue
+		}
+		if r.Switch.IsDialing(addr) {
+			continue
+		}
+		// leave it for a later tick rather than failing on the ip group cap
+		if r.Switch.NumPeersInGroup(addr) >= r.Switch.Config.MaxPeersPerIPGroup {
+			continue
+		}
+		toDial = append(toDial, addr)
+	}
+	if len(toDial) == 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{"toDial": len(toDial), "bookSize": r.book.Size()}).Info("start crawling address book")
+	sem := make(chan struct{}, r.Switch.Config.CrawlConcurrency)
+	var wg sync.WaitGroup
+	var pending int32 // dials started and not finished yet
+	for _, addr := range toDial {
+		select {
+		case sem <- struct{}{}:
+		case <-r.Quit:
+			wg.Wait()
+			return
+		}
+		if !r.waitOutboundSlot(&pending) {
+			wg.Wait()
+			return
+		}
+
+		atomic.AddInt32(&pending, 1)
+		wg.Add(1)
+		go func(addr *p2p.NetAddress) {
+			defer func() {
+				atomic.AddInt32(&pending, -1)
+				<-sem
+			}()
+			r.crawlPeerWorker(addr, &wg)
+		}(addr)
+	}
+	wg.Wait()
+	log.WithFields(log.Fields{"dialed": len(toDial), "bookSize": r.book.Size()}).Info("finish crawling address book")
+}
+
+func (r *PEXReactor) crawlPeerWorker(addr *p2p.NetAddress, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	err := r.Switch.DialPeerWithAddress(addr)
+	switch err {
+	case p2p.ErrMaxPeers, p2p.ErrMaxPeersInGroup:
+		// our own limits, the address wasn't contacted and is retried next tick
+		return
+	case p2p.ErrConnectSelf, p2p.ErrDuplicatePeer, p2p.ErrDuplicatePeerIP:
+		// contacted but says nothing about the address quality
+	case nil:
+		r.book.MarkGood(addr)
+	default:
+		r.book.MarkAttempt(addr)
+	}
+	r.crawledAt.Set(addr.String(), time.Now())
+}
+
+// waitOutboundSlot blocks until an outbound peer can be added, crawl peers
+// that didn't answer in time are disconnected meanwhile. pending counts the
+// dials in flight that will take a slot. It returns false when the reactor stops.
+func (r *PEXReactor) waitOutboundSlot(pending *int32) bool {
+	ticker := time.NewTicker(crawlSlotPeriod)
+	defer ticker.Stop()
+	for {
+		outbound, inbound, _ := r.Switch.NumPeers()
+		inFlight := int(atomic.LoadInt32(pending))
+		if outbound+inFlight < r.Switch.Config.MaxNumOutboundPeers &&
+			outbound+inbound+inFlight < r.Switch.Config.MaxNumPeers {
+			return true
+		}
+		r.expireCrawlPeers()
+
+		select {
+		case <-ticker.C:
+		case <-r.Quit:
+			return false
+		}
+	}
+}
+
+// pruneCrawledAt forgets the addresses due for a visit again, their entry
+// no longer skips them.
+func (r *PEXReactor) pruneCrawledAt(now time.Time, interval time.Duration) {
+	for _, key := range r.crawledAt.Keys() {
+		if last, ok := r.crawledAt.Get(key).(time.Time); !ok || now.Sub(last) >= interval {
+			r.crawledAt.Delete(key)
+		}
+	}
+}
+
+// startCrawlPeer asks a freshly dialed peer for its addresses, the peer is
+// disconnected once it answers or the request times out.
+func (r *PEXReactor) startCrawlPeer(peer *p2p.Peer) {
+	r.crawlPeers.Set(peer.Key, &crawlPeer{peer: peer, requestedAt: time.Now()})
+	r.RequestAddrs(peer)
+}
+
+// finishCrawlPeer disconnects the peer if it was dialed by the crawler.
+func (r *PEXReactor) finishCrawlPeer(peer *p2p.Peer) {
+	if !r.crawlPeers.Has(peer.Key) {
+		return
+	}
+	r.crawlPeers.Delete(peer.Key)
+	r.Switch.StopPeerGracefully(peer)
+}
+
+func (r *PEXReactor) expireCrawlPeers() {
+	for _, v := range r.crawlPeers.Values() {
+		cp := v.(*crawlPeer)
+		if time.Since(cp.requestedAt) < crawlPexTimeout {
+			continue
+		}
+		log.WithField("peer", cp.peer.Key).Debug("crawl peer didn't answer address request in time")
+		r.finishCrawlPeer(cp.peer)
+	}
+}
@@ -0,0 +1,16 @@
+package reactor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var pexMessagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "nodestats",
+	Subsystem: "pex",
+	Name:      "messages_received_total",
+	Help:      "Number of PEX messages received by type.",
+}, []string{"type"})
+
+func init() {
+	prometheus.MustRegister(pexMessagesReceived)
+}
@@ -2,11 +2,12 @@ package reactor
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 
 	wire "github.com/tendermint/go-wire"
 
-	"github.com/bytom/p2p"
+	"github.com/nodestats/p2p"
 )
 
 const (
@@ -26,6 +27,9 @@ var _ = wire.RegisterInterface(
 
 // DecodeMessage implements interface registered above.
 func DecodeMessage(bz []byte) (msgType byte, msg PexMessage, err error) {
+	if len(bz) == 0 {
+		return 0, nil, errors.New("empty pex message")
+	}
 	msgType = bz[0]
 	n := new(int)
 	r := bytes.NewReader(bz)
@@ -1,9 +1,13 @@
 package reactor
 
 import (
+	"errors"
+	"fmt"
 	"math/rand"
+	"reflect"
 
 	"github.com/nodestats/p2p"
+	"github.com/nodestats/p2p/connection"
 
 	log "github.com/sirupsen/logrus"
 	cmn "github.com/tendermint/tmlibs/common"
@@ -19,6 +23,12 @@ const (
 	minNumOutboundPeers      = 5
 	maxPexMessageSize        = 1048576 // 1MB
 	defaultMaxMsgCountByPeer = uint16(1000)
+	maxAddrsPerMessage       = 250 // the most GetSelection answers with
+)
+
+var (
+	errUnsolicitedAddrs = errors.New("received unsolicited pex addresses")
+	errTooManyMessages  = errors.New("too many pex messages")
 )
 
 // PEXReactor handles peer exchange and ensures that an adequate number of peers are connected to the switch.
@@ -26,6 +36,11 @@ type PEXReactor struct {
 	p2p.BaseReactor
 	book           *p2p.AddrBook
 	msgCountByPeer *cmn.CMap
+	requestsSent   *cmn.CMap // peers we are waiting for an address response from
+
+	crawlPeers *cmn.CMap
+	crawledAt  *cmn.CMap // address -> time of the latest crawl dial
+	seedPeers  *cmn.CMap // key -> inbound peer served in seed mode, waiting to be disconnected
 }
 
 // NewPEXReactor creates new PEX reactor.
@@ -33,17 +48,35 @@ func NewPEXReactor(b *p2p.AddrBook) *PEXReactor {
 	r := &PEXReactor{
 		book:           b,
 		msgCountByPeer: cmn.NewCMap(),
+		requestsSent:   cmn.NewCMap(),
+		crawlPeers:     cmn.NewCMap(),
+		seedPeers:      cmn.NewCMap(),
+		crawledAt:      cmn.NewCMap(),
 	}
 	r.BaseReactor = *p2p.NewBaseReactor("PEXReactor", r)
 	return r
 }
 
+// GetChannels implements Reactor
+func (r *PEXReactor) GetChannels() []*connection.ChannelDescriptor {
+	return []*connection.ChannelDescriptor{
+		&connection.ChannelDescriptor{
+			ID:                PexChannel,
+			Priority:          1,
+			SendQueueCapacity: 10,
+		},
+	}
+}
+
 // OnStart implements BaseService
 func (r *PEXReactor) OnStart() error {
 	r.BaseReactor.OnStart()
 
-
-	go r.ensurePeersRoutine()
+	if r.crawlMode() {
+		go r.crawlRoutine()
+	} else {
+		go r.ensurePeersRoutine()
+	}
 	go r.flushMsgCountByPeer()
 	return nil
 }
@@ -51,7 +84,86 @@ func (r *PEXReactor) OnStart() error {
 // OnStop implements BaseService
 func (r *PEXReactor) OnStop() {
 	r.BaseReactor.OnStop()
-	r.book.Stop()
+}
+
+// AddPeer implements Reactor by asking crawled peers for their addresses,
+// in seed mode inbound peers are sent a sample of the book.
+func (r *PEXReactor) AddPeer(p *p2p.Peer) error {
+	if r.crawlMode() && p.IsOutbound() {
+		r.startCrawlPeer(p)
+	}
+	if r.seedMode() && !p.IsOutbound() {
+		r.serveSeedPeer(p)
+	}
+	return nil
+}
+
+// RemovePeer implements Reactor
+func (r *PEXReactor) RemovePeer(p *p2p.Peer, reason interface{}) {
+	r.requestsSent.Delete(p.Key)
+	r.crawlPeers.Delete(p.Key)
+	if r.isSeedPeer(p) {
+		r.seedPeers.Delete(p.Key)
+	}
+}
+
+// Receive implements Reactor by handling incoming PEX messages.
+func (r *PEXReactor) Receive(chID byte, p *p2p.Peer, rawMsg []byte) {
+	if !r.countMessage(p) {
+		log.WithField("peer", p.Key).Warn("peer exceeded the pex message limit")
+		r.Switch.StopPeerForError(p, errTooManyMessages)
+		return
+	}
+
+	_, msg, err := DecodeMessage(rawMsg)
+	if err != nil {
+		log.WithField("error", err).Error("failed to decoding pex message")
+		r.Switch.StopPeerGracefully(p)
+		return
+	}
+
+	switch msg := msg.(type) {
+	case *pexRequestMessage:
+		pexMessagesReceived.WithLabelValues("request").Inc()
+		if r.isSeedPeer(p) {
+			// already got its sample when it connected
+			return
+		}
+		r.SendAddrs(p, r.book.GetSelection())
+	case *pexAddrsMessage:
+		pexMessagesReceived.WithLabelValues("addrs").Inc()
+		if !r.requestsSent.Has(p.Key) {
+			r.Switch.StopPeerForError(p, errUnsolicitedAddrs)
+			return
+		}
+		r.requestsSent.Delete(p.Key)
+		if len(msg.Addrs) > maxAddrsPerMessage {
+			r.Switch.StopPeerForError(p, fmt.Sprintf("pex address flood of %d addresses", len(msg.Addrs)))
+			return
+		}
+
+		srcAddr, err := p2p.NewNetAddressString(p.RemoteAddr)
+		if err != nil {
+			log.WithFields(log.Fields{"peer": p.Key, "err": err}).Error("fail to parse peer remote address")
+			return
+		}
+		for _, netAddr := range msg.Addrs {
+			if err := r.book.AddAddress(netAddr, srcAddr); err != nil {
+				log.WithFields(log.Fields{"address": netAddr, "err": err}).Debug("fail to add pex address")
+			}
+		}
+		r.Switch.Publish(&p2p.Event{
+			Type:     p2p.EventAddrsReceived,
+			Addr:     srcAddr,
+			NodeInfo: p.NodeInfo,
+			Outbound: p.IsOutbound(),
+			Addrs:    msg.Addrs,
+		})
+		r.finishCrawlPeer(p)
+	default:
+		pexMessagesReceived.WithLabelValues("unknown").Inc()
+		log.WithField("type", reflect.TypeOf(msg)).Debug("Ignoring unhandled pex message")
+	}
 }
 
 func (r *PEXReactor) dialPeerWorker(a *p2p.NetAddress, wg *sync.WaitGroup) {
@@ -142,8 +254,14 @@ func (r *PEXReactor) ensurePeersRoutine() {
 	}
 }
 
-// RequestAddrs asks peer for more addresses.
+// RequestAddrs asks peer for more addresses, only one request per peer is
+// outstanding at a time.
 func (r *PEXReactor) RequestAddrs(p *p2p.Peer) bool {
+	if r.requestsSent.Has(p.Key) {
+		return true
+	}
+	r.requestsSent.Set(p.Key, time.Now())
+
 	ok := p.TrySend(PexChannel, struct{ PexMessage }{&pexRequestMessage{}})
 	if !ok {
 		r.Switch.StopPeerGracefully(p)
@@ -151,15 +269,65 @@ func (r *PEXReactor) RequestAddrs(p *p2p.Peer) bool {
 	return ok
 }
 
+// SendAddrs sends addrs to the peer.
+func (r *PEXReactor) SendAddrs(p *p2p.Peer, addrs []*p2p.NetAddress) bool {
+	ok := p.TrySend(PexChannel, struct{ PexMessage }{&pexAddrsMessage{Addrs: addrs}})
+	if !ok {
+		r.Switch.StopPeerGracefully(p)
+	}
+	return ok
+}
+
+// countMessage increases the message count of the peer, it returns false
+// once the peer sent more than defaultMaxMsgCountByPeer since the last flush.
+func (r *PEXReactor) countMessage(p *p2p.Peer) bool {
+	var count uint16
+	if v := r.msgCountByPeer.Get(p.Key); v != nil {
+		count = v.(uint16)
+	}
+	if count >= defaultMaxMsgCountByPeer {
+		return false
+	}
+	r.msgCountByPeer.Set(p.Key, count+1)
+	return true
+}
+
 func (r *PEXReactor) dialSeeds() {
-	if r.Switch.Config.Seeds == "" {
+	netAddrs := r.addSeeds()
+	if len(netAddrs) == 0 {
 		return
 	}
 
-	seeds := strings.Split(r.Switch.Config.Seeds, ",")
-	netAddrs, err := p2p.NewNetAddressStrings(seeds)
-	if err != nil {
-		log.WithField("err", err).Error("dialSeeds: fail to decode net address strings")
+	if err := r.book.SaveToFile(); err != nil {
+		log.WithField("err", err).Warn("dialSeeds: fail to save address book")
+	}
+
+	for _, i := range rand.Perm(len(netAddrs)) {
+		if err := r.Switch.DialPeerWithAddress(netAddrs[i]); err != nil {
+			log.WithFields(log.Fields{"address": netAddrs[i], "err": err}).Warn("dialSeeds: fail to dial seed")
+		}
+	}
+}
+
+// addSeeds resolves the seeds and adds their addresses to the book, it
+// returns them without our own address.
+func (r *PEXReactor) addSeeds() []*p2p.NetAddress {
+	if r.Switch.Config.Seeds == "" {
+		return nil
+	}
+
+	// hostnames are resolved on every call, seed hosts may rotate their IPs
+	netAddrs := []*p2p.NetAddress{}
+	for _, seed := range strings.Split(r.Switch.Config.Seeds, ",") {
+		if seed = strings.TrimSpace(seed); seed == "" {
+			continue
+		}
+		addrs, err := p2p.ResolveNetAddresses(seed)
+		if err != nil {
+			log.WithFields(log.Fields{"seed": seed, "err": err}).Error("dialSeeds: fail to resolve seed")
+			continue
+		}
+		netAddrs = append(netAddrs, addrs...)
 	}
 
 	ourAddr, err := p2p.NewNetAddressString(r.Switch.NodeInfo().ListenAddr)
@@ -167,25 +335,17 @@ func (r *PEXReactor) dialSeeds() {
 		log.WithField("err", err).Error("dialSeeds: fail to get our address")
 	}
 
+	seeds := make([]*p2p.NetAddress, 0, len(netAddrs))
 	for _, netAddr := range netAddrs {
-		if netAddr.Equals(ourAddr) {
+		if ourAddr != nil && netAddr.Equals(ourAddr) {
 			continue
 		}
 		if err := r.book.AddAddress(netAddr, ourAddr); err != nil {
 			log.WithField("err", err).Warn("dialSeeds: fail to add address")
 		}
+		seeds = append(seeds, netAddr)
 	}
-
-	if err := r.book.SaveToFile(); err != nil {
-		log.WithField("err", err).Warn("dialSeeds: fail to save address book")
-	}
-
-	perm := rand.Perm(len(netAddrs))
-	for i := 0; i < len(perm); i += 2 {
-		if err := r.Switch.DialPeerWithAddress(netAddrs[perm[i]]); err != nil {
-			log.WithField("err", err).Warn("dialSeeds: fail to dial seed")
-		}
-	}
+	return seeds
 }
 
 func (r *PEXReactor) flushMsgCountByPeer() {
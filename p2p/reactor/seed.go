@@ -0,0 +1,38 @@
+package reactor
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nodestats/p2p"
+)
+
+// seedGracePeriod lets the address sample reach the peer before it is disconnected
+const seedGracePeriod = 3 * time.Second
+
+func (r *PEXReactor) seedMode() bool {
+	return r.Switch.Config.SeedMode
+}
+
+// serveSeedPeer sends an inbound peer one address sample and disconnects it
+// once the grace period is over. The timer only acts on the connection it was
+// started for, the node may have reconnected under the same key meanwhile.
+func (r *PEXReactor) serveSeedPeer(peer *p2p.Peer) {
+	r.seedPeers.Set(peer.Key, peer)
+	r.SendAddrs(peer, r.book.GetSelection())
+
+	time.AfterFunc(seedGracePeriod, func() {
+		if !r.isSeedPeer(peer) {
+			return
+		}
+		log.WithField("peer", peer.Key).Debug("disconnecting served seed peer")
+		r.Switch.StopPeerGracefully(peer)
+	})
+}
+
+// isSeedPeer tells if peer is the connection being served under its key
+func (r *PEXReactor) isSeedPeer(peer *p2p.Peer) bool {
+	served, ok := r.seedPeers.Get(peer.Key).(*p2p.Peer)
+	return ok && served == peer
+}
@@ -2,6 +2,9 @@ package p2p
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"strings"
 	"sync"
 
 	"github.com/tendermint/go-crypto"
@@ -18,35 +21,108 @@ import (
 //pre-define errors for connecting fail
 var (
 	ErrDuplicatePeer     = errors.New("Duplicate peer")
+	ErrDuplicatePeerIP   = errors.New("Duplicate peer IP")
 	ErrConnectSelf       = errors.New("Connect self")
 	ErrConnectBannedPeer = errors.New("Connect banned peer")
+	ErrMaxPeers          = errors.New("Max number of peers reached")
+	ErrMaxPeersInGroup   = errors.New("Max number of peers in ip group reached")
 )
 
+// IncompatiblePeerError is returned by AddPeer when the peer's NodeInfo is not compatible with ours.
+type IncompatiblePeerError struct {
+	NodeInfo *NodeInfo
+	Reason   error
+}
+
+func (e *IncompatiblePeerError) Error() string {
+	return e.Reason.Error()
+}
+
 type Switch struct {
-	Config       *cfg.Config
-	peerConfig   *PeerConfig
+	cmn.BaseService
+
+	Config     *cfg.P2PConfig
+	peerConfig *PeerConfig
 
-	chDescs      []*connection.ChannelDescriptor
-	reactorsByCh map[byte]Reactor
-	addrBook     *AddrBook
-	nodePrivKey  crypto.PrivKeyEd25519
-	peers        *PeerSet
-	dialing		 *cmn.CMap
-	nodeInfo     *NodeInfo
-	mtx          sync.Mutex
-	reactors     map[string]Reactor
+	listeners     []Listener
+	chDescs       []*connection.ChannelDescriptor
+	reactorsByCh  map[byte]Reactor
+	addrBook      *AddrBook
+	nodePrivKey   crypto.PrivKeyEd25519
+	peers         *PeerSet
+	dialing       *cmn.CMap
+	nodeInfo      *NodeInfo
+	mtx           sync.Mutex
+	reactors      map[string]Reactor
+	reserved      map[string]struct{} // pubkeys admitted regardless of the limits
+	events        *eventBus
+	versionPolicy VersionPolicy
+	addrVoter     *addrVoter
+	nodeInfoMtx   sync.RWMutex   // guards nodeInfo once the switch is running
+	inboundSlots  chan struct{}  // bounds the inbound handshakes in flight
+	pendingMtx    sync.Mutex     // guards pending
+	pending       map[string]int // inbound handshakes in flight by remote host
 }
 
-func NewSwitch(config *cfg.Config, addrBook *AddrBook) *Switch {
+func NewSwitch(config *cfg.P2PConfig, addrBook *AddrBook) *Switch {
 	sw := &Switch{
-		onfig: config,
-		addrBook: addrBook,
-		nodeInfo: nil,
+		Config:        config,
+		peerConfig:    DefaultPeerConfig(config),
+		listeners:     make([]Listener, 0),
+		chDescs:       make([]*connection.ChannelDescriptor, 0),
+		reactorsByCh:  make(map[byte]Reactor),
+		addrBook:      addrBook,
+		peers:         NewPeerSet(),
+		dialing:       cmn.NewCMap(),
+		nodeInfo:      nil,
+		reactors:      make(map[string]Reactor),
+		reserved:      make(map[string]struct{}),
+		events:        newEventBus(),
+		versionPolicy: exactMinorPolicy{},
+		addrVoter:     newAddrVoter(),
+		pending:       make(map[string]int),
 	}
+	for _, key := range strings.Split(config.ReservedPeers, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			sw.reserved[key] = struct{}{}
+		}
+	}
+	sw.inboundSlots = make(chan struct{}, config.MaxNumInboundPeers+len(sw.reserved))
+	sw.BaseService = *cmn.NewBaseService(nil, "P2P Switch", sw)
 	return sw
 }
 
-func (sw *Switch) Start() error {
+// AddReactor adds the given reactor to the switch.
+// NOTE: Not goroutine safe.
+func (sw *Switch) AddReactor(name string, reactor Reactor) Reactor {
+	// No two reactors can share the same channel.
+	for _, chDesc := range reactor.GetChannels() {
+		chID := chDesc.ID
+		if sw.reactorsByCh[chID] != nil {
+			cmn.PanicSanity(fmt.Sprintf("Channel %X has multiple reactors %v & %v", chID, sw.reactorsByCh[chID], reactor))
+		}
+		sw.chDescs = append(sw.chDescs, chDesc)
+		sw.reactorsByCh[chID] = reactor
+	}
+	sw.reactors[name] = reactor
+	reactor.SetSwitch(sw)
+	return reactor
+}
+
+// AddListener adds the given listener to the switch for listening to incoming peer connections.
+// NOTE: Not goroutine safe.
+func (sw *Switch) AddListener(l Listener) {
+	sw.listeners = append(sw.listeners, l)
+}
+
+// Listeners returns the list of listeners the switch listens on.
+// NOTE: Not goroutine safe.
+func (sw *Switch) Listeners() []Listener {
+	return sw.listeners
+}
+
+// OnStart implements BaseService. It starts all the reactors and listeners.
+func (sw *Switch) OnStart() error {
 	// Start reactors
 	for _, reactor := range sw.reactors {
 		_, err := reactor.Start()
@@ -55,12 +131,27 @@ func (sw *Switch) Start() error {
 		}
 	}
 	// Start listeners
-	//for _, listener := range sw.listeners {
-	//	go sw.listenerRoutine(listener)
-	//}
+	for _, listener := range sw.listeners {
+		go sw.listenerRoutine(listener)
+	}
 	return nil
 }
 
+// OnStop implements BaseService. It stops all the listeners, peers and reactors.
+func (sw *Switch) OnStop() {
+	for _, listener := range sw.listeners {
+		listener.Stop()
+	}
+	sw.listeners = nil
+
+	for _, peer := range sw.peers.List() {
+		sw.stopAndRemovePeer(peer, nil)
+	}
+	for _, reactor := range sw.reactors {
+		reactor.Stop()
+	}
+}
+
 // List threadsafe list of peers.
 func (ps *PeerSet) List() []*Peer {
 	ps.mtx.Lock()
@@ -96,53 +187,332 @@ func (sw *Switch) IsDialing(addr *NetAddress) bool {
 // that already has a SecretConnection. If all goes well,
 // it starts the peer and adds it to the switch.
 // NOTE: This performs a blocking handshake before the peer is added.
-// CONTRACT: If error is returned, peer is nil, and conn is immediately closed.
-func (sw *Switch) AddPeer(pc *peerConn) error {
-	peerNodeInfo, err := pc.HandshakeTimeout(sw.nodeInfo, time.Duration(sw.peerConfig.HandshakeTimeout*time.Second))
+// CONTRACT: If error is returned, peer is nil, and conn is closed, by the
+// stopped peer once it was started. Callers never close it.
+func (sw *Switch) AddPeer(pc *peerConn) (err error) {
+	started := false
+	defer func() {
+		if err != nil && !started {
+			pc.CloseConn()
+		}
+	}()
+
+	handshakeStart := time.Now()
+	ourNodeInfo := sw.NodeInfo().withObservedAddr(pc.conn.RemoteAddr().String())
+	peerNodeInfo, err := pc.HandshakeTimeout(ourNodeInfo, time.Duration(sw.peerConfig.HandshakeTimeout*time.Second))
 	if err != nil {
 		return err
 	}
+	handshakeLatency.Observe(time.Since(handshakeStart).Seconds())
+	observedAddr := peerNodeInfo.takeObservedAddr()
 
-	if err := sw.nodeInfo.CompatibleWith(peerNodeInfo); err != nil {
-		return err
+	if err := sw.NodeInfo().CompatibleWith(peerNodeInfo, sw.versionPolicy); err != nil {
+		if sw.Config.ObserveIncompatible {
+			// SubscribeFunc handlers, the stats recorder among them, store
+			// the rejection before we return and the connection is closed
+			sw.publish(&Event{Type: EventHandshakeRejected, Addr: pc.remoteAddr(), NodeInfo: peerNodeInfo, Outbound: pc.outbound, Err: err})
+		}
+		return &IncompatiblePeerError{NodeInfo: peerNodeInfo, Reason: err}
 	}
 
 	peer := newPeer(pc, peerNodeInfo, sw.reactorsByCh, sw.chDescs, sw.StopPeerForError)
-	//if err := sw.filterConnByPeer(peer); err != nil {
-	//	return err
-	//}
+	if err := sw.admitPeer(peer); err != nil {
+		return err
+	}
+	sw.recordObservedAddr(observedAddr, pc.remoteAddr())
+	sw.publish(&Event{Type: EventHandshakeCompleted, Addr: pc.remoteAddr(), NodeInfo: peerNodeInfo, Outbound: pc.outbound})
 
+	started = true
 	if err := sw.startInitPeer(peer); err != nil {
+		sw.stopAndRemovePeer(peer, err)
 		return err
 	}
-
-	return sw.peers.Add(peer)
+	// a reactor may already have stopped the peer
+	if sw.peers.Has(peer.Key) {
+		sw.publish(&Event{Type: EventPeerAdded, Addr: pc.remoteAddr(), NodeInfo: peerNodeInfo, Outbound: pc.outbound})
+	}
+	return nil
 }
 
 //DialPeerWithAddress dial node from net address
 func (sw *Switch) DialPeerWithAddress(addr *NetAddress) error {
 	log.Debug("Dialing peer address:", addr)
+	dialAttempts.Inc()
 	sw.dialing.Set(addr.IP.String(), addr)
 	defer sw.dialing.Delete(addr.IP.String())
-	//if err := sw.filterConnByIP(addr.IP.String()); err != nil {
-	//	return err
-	//}
+	if err := sw.filterConnByIP(addr.IP.String()); err != nil {
+		sw.dialFailed(addr, nil, err)
+		return err
+	}
 
+	sw.publish(&Event{Type: EventDialStarted, Addr: addr, Outbound: true})
 	pc, err := newOutboundPeerConn(addr, sw.nodePrivKey, sw.peerConfig)
 	if err != nil {
 		log.WithFields(log.Fields{"address": addr, " err": err}).Debug("DialPeer fail on newOutboundPeerConn")
+		sw.dialFailed(addr, nil, err)
 		return err
 	}
 
 	if err = sw.AddPeer(pc); err != nil {
 		log.WithFields(log.Fields{"address": addr, " err": err}).Debug("DialPeer fail on switch AddPeer")
-		pc.CloseConn()
+		var nodeInfo *NodeInfo
+		if incompatible, ok := err.(*IncompatiblePeerError); ok {
+			nodeInfo = incompatible.NodeInfo
+			// observed nodes of other networks stay in the book so the
+			// crawler revisits them every crawl interval
+			if nodeInfo.Network != sw.NodeInfo().Network && !sw.Config.ObserveIncompatible {
+				sw.banAddress(addr, nodeInfo, incompatible.Error())
+			}
+		}
+		sw.dialFailed(addr, nodeInfo, err)
 		return err
 	}
+	dialSuccesses.Inc()
 	log.Debug("DialPeer added peer:", addr)
 	return nil
 }
 
+func (sw *Switch) dialFailed(addr *NetAddress, nodeInfo *NodeInfo, err error) {
+	dialFailures.WithLabelValues(DialErrorClass(err)).Inc()
+	sw.publish(&Event{Type: EventDialFailed, Addr: addr, NodeInfo: nodeInfo, Outbound: true, Err: err})
+}
+
+func (sw *Switch) addPeerWithConnection(conn net.Conn) error {
+	pc, err := newInboundPeerConn(conn, sw.nodePrivKey, sw.peerConfig)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	return sw.AddPeer(pc)
+}
+
+func (sw *Switch) listenerRoutine(l Listener) {
+	for {
+		inConn, ok := <-l.Connections()
+		if !ok {
+			break
+		}
+
+		host, _, err := net.SplitHostPort(inConn.RemoteAddr().String())
+		if err == nil {
+			err = sw.filterConnByIP(host)
+		}
+		if err == nil {
+			err = sw.checkInboundLimits(host)
+		}
+		if err == nil {
+			err = sw.acquireInboundSlot(host)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"address": inConn.RemoteAddr().String(), " err": err}).Debug("Ignoring inbound connection")
+			inConn.Close()
+			continue
+		}
+
+		// New inbound connection, the handshake runs in its own routine
+		// so a slow peer can't hold up the accept loop.
+		go func(conn net.Conn, host string) {
+			defer sw.releaseInboundSlot(host)
+			if err := sw.addPeerWithConnection(conn); err != nil {
+				log.WithFields(log.Fields{"address": conn.RemoteAddr().String(), " err": err}).Debug("Ignoring inbound connection: error while adding peer")
+			}
+		}(inConn, host)
+	}
+}
+
+// acquireInboundSlot counts an inbound handshake in flight, it fails when
+// as many handshakes as inbound peers are already running.
+func (sw *Switch) acquireInboundSlot(host string) error {
+	select {
+	case sw.inboundSlots <- struct{}{}:
+	default:
+		return ErrMaxPeers
+	}
+	sw.pendingMtx.Lock()
+	sw.pending[host]++
+	sw.pendingMtx.Unlock()
+	return nil
+}
+
+func (sw *Switch) releaseInboundSlot(host string) {
+	sw.pendingMtx.Lock()
+	if sw.pending[host]--; sw.pending[host] <= 0 {
+		delete(sw.pending, host)
+	}
+	sw.pendingMtx.Unlock()
+	<-sw.inboundSlots
+}
+
+func (sw *Switch) hasPendingIP(host string) bool {
+	sw.pendingMtx.Lock()
+	defer sw.pendingMtx.Unlock()
+	return sw.pending[host] > 0
+}
+
+// numPendingInGroup returns the number of inbound handshakes in flight from the ip group
+func (sw *Switch) numPendingInGroup(group string) int {
+	sw.pendingMtx.Lock()
+	defer sw.pendingMtx.Unlock()
+	count := 0
+	for host, n := range sw.pending {
+		if ip := net.ParseIP(host); ip != nil && sw.addrBook.GroupKey(NewNetAddressIPPort(ip, 0)) == group {
+			count += n
+		}
+	}
+	return count
+}
+
+// BanPeer bans the peer's IP and pubkey for the configured duration and disconnects it.
+func (sw *Switch) BanPeer(peer *Peer, reason string) {
+	duration := time.Duration(sw.Config.BanDuration) * time.Second
+	if ip := net.ParseIP(peer.RemoteAddrHost()); ip != nil {
+		sw.addrBook.BanIP(ip, duration, reason)
+	}
+	sw.addrBook.BanPubKey(peer.Key, duration, reason)
+	sw.StopPeerForError(peer, reason)
+}
+
+// banAddress drops a dialed address from the book and bans it with the node behind it.
+func (sw *Switch) banAddress(addr *NetAddress, nodeInfo *NodeInfo, reason string) {
+	duration := time.Duration(sw.Config.BanDuration) * time.Second
+	sw.addrBook.MarkBad(addr, duration, reason)
+	sw.addrBook.BanPubKey(nodeInfo.PubKey.KeyString(), duration, reason)
+}
+
+func (sw *Switch) checkBannedPeer(key string) error {
+	if ban, ok := sw.addrBook.IsBanned(key); ok {
+		log.WithFields(log.Fields{"key": key, "reason": ban.Reason, "until": ban.Until}).Debug("refuse banned peer")
+		return ErrConnectBannedPeer
+	}
+	return nil
+}
+
+func (sw *Switch) filterConnByIP(ip string) error {
+	if err := sw.checkBannedPeer(ip); err != nil {
+		return err
+	}
+	if ip == sw.NodeInfo().ListenHost() {
+		return ErrConnectSelf
+	}
+	if sw.Config.DedupeIP && (sw.peers.HasIP(ip) || sw.hasPendingIP(ip)) {
+		return ErrDuplicatePeerIP
+	}
+	return nil
+}
+
+func (sw *Switch) filterConnByPeer(peer *Peer) error {
+	if err := sw.checkBannedPeer(peer.RemoteAddrHost()); err != nil {
+		return err
+	}
+	if err := sw.checkBannedPeer(peer.Key); err != nil {
+		return err
+	}
+
+	if sw.NodeInfo().PubKey == peer.PubKey {
+		if peer.IsOutbound() {
+			sw.markOurAddress(peer.RemoteAddr)
+		}
+		return ErrConnectSelf
+	}
+
+	if sw.peers.Has(peer.Key) {
+		return ErrDuplicatePeer
+	}
+	// nodes sharing an IP, e.g. behind a NAT, only count once when deduped
+	if sw.Config.DedupeIP && sw.peers.HasIP(peer.RemoteAddrHost()) {
+		return ErrDuplicatePeerIP
+	}
+	return nil
+}
+
+// admitPeer runs the filters and limits and adds the peer to the peer set,
+// all under the switch lock so concurrent handshakes can't overshoot the limits.
+func (sw *Switch) admitPeer(peer *Peer) error {
+	sw.mtx.Lock()
+	defer sw.mtx.Unlock()
+
+	if err := sw.filterConnByPeer(peer); err != nil {
+		return err
+	}
+	if err := sw.checkPeerLimits(peer); err != nil {
+		return err
+	}
+	// Add to the peer set before reactors see the peer
+	return sw.peers.Add(peer)
+}
+
+// checkPeerLimits enforces the direction, total and ip group caps on a
+// handshaked peer. Reserved peers are always admitted.
+func (sw *Switch) checkPeerLimits(peer *Peer) error {
+	if _, ok := sw.reserved[peer.Key]; ok {
+		return nil
+	}
+
+	outbound, inbound, _ := sw.NumPeers()
+	if outbound+inbound >= sw.Config.MaxNumPeers {
+		return ErrMaxPeers
+	}
+	if peer.IsOutbound() && outbound >= sw.Config.MaxNumOutboundPeers {
+		return ErrMaxPeers
+	}
+	if !peer.IsOutbound() && inbound >= sw.Config.MaxNumInboundPeers {
+		return ErrMaxPeers
+	}
+
+	if ip := net.ParseIP(peer.RemoteAddrHost()); ip != nil {
+		if sw.NumPeersInGroup(NewNetAddressIPPort(ip, 0)) >= sw.Config.MaxPeersPerIPGroup {
+			return ErrMaxPeersInGroup
+		}
+	}
+	return nil
+}
+
+// checkInboundLimits is the cheap check done on accept, before the handshake.
+// Reserved peers are only known after the handshake, so it leaves room for them
+// and the exact limits are applied by checkPeerLimits. Handshakes in flight
+// count towards the ip group.
+func (sw *Switch) checkInboundLimits(host string) error {
+	_, inbound, _ := sw.NumPeers()
+	if inbound >= sw.Config.MaxNumInboundPeers+len(sw.reserved) {
+		return ErrMaxPeers
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	addr := NewNetAddressIPPort(ip, 0)
+	inGroup := sw.NumPeersInGroup(addr) + sw.numPendingInGroup(sw.addrBook.GroupKey(addr))
+	if inGroup >= sw.Config.MaxPeersPerIPGroup+len(sw.reserved) {
+		return ErrMaxPeersInGroup
+	}
+	return nil
+}
+
+// NumPeersInGroup returns the number of connected peers in the ip group of the address
+func (sw *Switch) NumPeersInGroup(addr *NetAddress) int {
+	group := sw.addrBook.GroupKey(addr)
+	count := 0
+	for _, peer := range sw.peers.List() {
+		ip := net.ParseIP(peer.RemoteAddrHost())
+		if ip != nil && sw.addrBook.GroupKey(NewNetAddressIPPort(ip, 0)) == group {
+			count++
+		}
+	}
+	return count
+}
+
+// markOurAddress stops the address book from handing out an address that turned out to be us.
+func (sw *Switch) markOurAddress(remoteAddr string) {
+	addr, err := NewNetAddressString(remoteAddr)
+	if err != nil {
+		log.WithFields(log.Fields{"address": remoteAddr, "err": err}).Error("fail to parse our own address")
+		return
+	}
+	sw.addrBook.AddOurAddress(addr)
+}
+
 // StopPeerForError disconnects from a peer due to external error.
 func (sw *Switch) StopPeerForError(peer *Peer, reason interface{}) {
 	log.WithFields(log.Fields{"peer": peer, " err": reason}).Debug("stopping peer for error")
@@ -163,7 +533,18 @@ func (sw *Switch) stopAndRemovePeer(peer *Peer, reason interface{}) {
 	for _, reactor := range sw.reactors {
 		reactor.RemovePeer(peer, reason)
 	}
-	sw.peers.Remove(peer)
+	if sw.peers.Remove(peer) {
+		status := peer.Status()
+		sw.publish(&Event{
+			Type:     EventPeerRemoved,
+			Addr:     peer.remoteAddr(),
+			NodeInfo: peer.NodeInfo,
+			Outbound: peer.IsOutbound(),
+			Reason:   reason,
+			Duration: time.Since(peer.ConnectedAt()),
+			Ping:     &status.Ping,
+		})
+	}
 	peer.Stop()
 }
 
@@ -172,9 +553,31 @@ func (sw *Switch) StopPeerGracefully(peer *Peer) {
 	sw.stopAndRemovePeer(peer, nil)
 }
 
-// NodeInfo returns the switch's NodeInfo.
-// NOTE: Not goroutine safe.
+// NodeInfo returns the switch's NodeInfo. It is replaced, never modified,
+// when peers teach us our external address so it must be treated as read only.
 func (sw *Switch) NodeInfo() *NodeInfo {
+	sw.nodeInfoMtx.RLock()
+	defer sw.nodeInfoMtx.RUnlock()
 	return sw.nodeInfo
 }
 
+// SetNodeInfo sets the switch's NodeInfo for checking compatibility and handshaking with other nodes.
+// NOTE: Not goroutine safe.
+func (sw *Switch) SetNodeInfo(nodeInfo *NodeInfo) {
+	sw.nodeInfo = nodeInfo
+}
+
+// SetVersionPolicy sets the policy deciding which peer versions are compatible.
+// NOTE: Not goroutine safe.
+func (sw *Switch) SetVersionPolicy(policy VersionPolicy) {
+	sw.versionPolicy = policy
+}
+
+// SetNodePrivKey sets the switch's private key for authenticated encryption.
+// NOTE: Not goroutine safe.
+func (sw *Switch) SetNodePrivKey(nodePrivKey crypto.PrivKeyEd25519) {
+	sw.nodePrivKey = nodePrivKey
+	if sw.nodeInfo != nil {
+		sw.nodeInfo.PubKey = nodePrivKey.PubKey().Unwrap().(crypto.PubKeyEd25519)
+	}
+}
@@ -0,0 +1,96 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/tendermint/go-crypto"
+
+	cfg "github.com/nodestats/config"
+)
+
+func testSwitch(dedupeIP bool) *Switch {
+	config := cfg.DefaultP2PConfig()
+	config.DedupeIP = dedupeIP
+	sw := NewSwitch(config, NewAddrBook("", false, 0))
+	sw.SetNodeInfo(&NodeInfo{PubKey: crypto.PubKeyEd25519{1}, ListenAddr: "10.0.0.1:46656"})
+	return sw
+}
+
+func testPeer(key byte, remoteAddr string, outbound bool) *Peer {
+	pubKey := crypto.PubKeyEd25519{key}
+	return &Peer{
+		peerConn: &peerConn{outbound: outbound},
+		NodeInfo: &NodeInfo{PubKey: pubKey, RemoteAddr: remoteAddr},
+		Key:      pubKey.KeyString(),
+	}
+}
+
+func TestSwitchFiltersSelf(t *testing.T) {
+	sw := testSwitch(true)
+	if err := sw.filterConnByIP("10.0.0.1"); err != ErrConnectSelf {
+		t.Errorf("expected ErrConnectSelf dialing our listen host, got %v", err)
+	}
+
+	if err := sw.filterConnByPeer(testPeer(1, "5.6.7.8:46656", false)); err != ErrConnectSelf {
+		t.Errorf("expected ErrConnectSelf for an inbound connection from us, got %v", err)
+	}
+	if len(sw.addrBook.ourAddrs) != 0 {
+		t.Errorf("expected the remote port of an inbound connection to be ignored, got %v", sw.addrBook.ourAddrs)
+	}
+
+	if err := sw.filterConnByPeer(testPeer(1, "5.6.7.8:46656", true)); err != ErrConnectSelf {
+		t.Errorf("expected ErrConnectSelf dialing ourselves, got %v", err)
+	}
+	if _, ok := sw.addrBook.ourAddrs["5.6.7.8:46656"]; !ok {
+		t.Errorf("expected the dialed address to be marked as ours, got %v", sw.addrBook.ourAddrs)
+	}
+}
+
+func TestSwitchFiltersDuplicates(t *testing.T) {
+	sw := testSwitch(true)
+	if err := sw.peers.Add(testPeer(2, "5.6.7.8:1000", false)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sw.filterConnByPeer(testPeer(2, "9.9.9.9:46656", true)); err != ErrDuplicatePeer {
+		t.Errorf("expected ErrDuplicatePeer for a connected pubkey, got %v", err)
+	}
+	if err := sw.filterConnByPeer(testPeer(3, "5.6.7.8:2000", false)); err != ErrDuplicatePeerIP {
+		t.Errorf("expected ErrDuplicatePeerIP for a connected IP, got %v", err)
+	}
+	if err := sw.filterConnByIP("5.6.7.8"); err != ErrDuplicatePeerIP {
+		t.Errorf("expected ErrDuplicatePeerIP dialing a connected IP, got %v", err)
+	}
+	if err := sw.filterConnByPeer(testPeer(3, "9.9.9.9:46656", true)); err != nil {
+		t.Errorf("expected a new pubkey and IP to pass, got %v", err)
+	}
+
+	// inbound handshakes in flight count as connected IPs
+	if err := sw.acquireInboundSlot("6.6.6.6"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.filterConnByIP("6.6.6.6"); err != ErrDuplicatePeerIP {
+		t.Errorf("expected ErrDuplicatePeerIP during the handshake, got %v", err)
+	}
+	sw.releaseInboundSlot("6.6.6.6")
+	if err := sw.filterConnByIP("6.6.6.6"); err != nil {
+		t.Errorf("expected the IP to pass once the handshake is over, got %v", err)
+	}
+}
+
+func TestSwitchSharedIP(t *testing.T) {
+	sw := testSwitch(false)
+	if err := sw.peers.Add(testPeer(2, "5.6.7.8:1000", false)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sw.filterConnByIP("5.6.7.8"); err != nil {
+		t.Errorf("expected a connected IP to pass without dedupe, got %v", err)
+	}
+	if err := sw.filterConnByPeer(testPeer(3, "5.6.7.8:2000", false)); err != nil {
+		t.Errorf("expected a second node behind the IP to pass without dedupe, got %v", err)
+	}
+	if err := sw.filterConnByPeer(testPeer(2, "9.9.9.9:46656", true)); err != ErrDuplicatePeer {
+		t.Errorf("expected ErrDuplicatePeer for a connected pubkey, got %v", err)
+	}
+}
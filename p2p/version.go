@@ -0,0 +1,295 @@
+package p2p
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// version policy names accepted in the config
+const (
+	VersionPolicyExactMinor = "exact_minor"
+	VersionPolicySameMajor  = "same_major"
+	VersionPolicyAllowList  = "allow_list"
+)
+
+// Version is a semantic version, see https://semver.org. Leading zeros and a
+// "v" prefix are tolerated when parsing since nodes in the wild announce them.
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	Build      []string
+}
+
+// ParseVersion parses a major.minor.patch[-prerelease][+build] version
+func ParseVersion(s string) (*Version, error) {
+	raw := strings.TrimSpace(s)
+	raw = strings.TrimPrefix(strings.TrimPrefix(raw, "v"), "V")
+
+	v := &Version{}
+	if i := strings.Index(raw, "+"); i >= 0 {
+		if v.Build = strings.Split(raw[i+1:], "."); !validIdentifiers(v.Build) {
+			return nil, fmt.Errorf("Invalid build metadata in version %v", s)
+		}
+		raw = raw[:i]
+	}
+	if i := strings.Index(raw, "-"); i >= 0 {
+		if v.Prerelease = strings.Split(raw[i+1:], "."); !validIdentifiers(v.Prerelease) {
+			return nil, fmt.Errorf("Invalid pre-release in version %v", s)
+		}
+		raw = raw[:i]
+	}
+
+	spl := strings.Split(raw, ".")
+	if len(spl) != 3 {
+		return nil, fmt.Errorf("Invalid version format %v", s)
+	}
+	core := make([]uint64, 3)
+	for i, part := range spl {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid version format %v", s)
+		}
+		core[i] = n
+	}
+	v.Major, v.Minor, v.Patch = core[0], core[1], core[2]
+	return v, nil
+}
+
+func validIdentifiers(ids []string) bool {
+	for _, id := range ids {
+		if id == "" {
+			return false
+		}
+		for _, c := range id {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// NormalizeVersion returns the canonical form of the version without build
+// metadata, so "v01.0.3+abc" and "1.0.3" are grouped together. Versions that
+// don't parse are returned as is.
+func NormalizeVersion(s string) string {
+	v, err := ParseVersion(s)
+	if err != nil {
+		return strings.TrimSpace(s)
+	}
+	return v.Precedence()
+}
+
+// Precedence returns the version without its build metadata
+func (v *Version) Precedence() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	return s
+}
+
+func (v *Version) String() string {
+	s := v.Precedence()
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 if v has a lower, equal or higher precedence than o.
+// Build metadata is ignored.
+func (v *Version) Compare(o *Version) int {
+	if c := compareUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+
+	// a pre-release has a lower precedence than the release itself
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(o.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := comparePrerelease(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.Prerelease)), uint64(len(o.Prerelease)))
+}
+
+// comparePrerelease compares numeric identifiers numerically and the others
+// lexically, numeric identifiers sort first.
+func comparePrerelease(a, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareUint(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// VersionPolicy decides if a peer version is compatible with ours
+type VersionPolicy interface {
+	Compatible(ours, theirs *Version) error
+}
+
+// NewVersionPolicy returns the policy registered under name, the allow list
+// is only used by the allow_list policy.
+func NewVersionPolicy(name, allowList string) (VersionPolicy, error) {
+	switch name {
+	case "", VersionPolicyExactMinor:
+		return exactMinorPolicy{}, nil
+	case VersionPolicySameMajor:
+		return sameMajorPolicy{}, nil
+	case VersionPolicyAllowList:
+		return newAllowListPolicy(allowList)
+	}
+	return nil, fmt.Errorf("Unknown version policy %v", name)
+}
+
+// exactMinorPolicy requires the same major and minor version
+type exactMinorPolicy struct{}
+
+func (exactMinorPolicy) Compatible(ours, theirs *Version) error {
+	if ours.Major != theirs.Major {
+		return fmt.Errorf("Peer is on a different major version. Got %v, expected %v", theirs.Major, ours.Major)
+	}
+	if ours.Minor != theirs.Minor {
+		return fmt.Errorf("Peer is on a different minor version. Got %v, expected %v", theirs.Minor, ours.Minor)
+	}
+	return nil
+}
+
+// sameMajorPolicy requires the same major version
+type sameMajorPolicy struct{}
+
+func (sameMajorPolicy) Compatible(ours, theirs *Version) error {
+	if ours.Major != theirs.Major {
+		return fmt.Errorf("Peer is on a different major version. Got %v, expected %v", theirs.Major, ours.Major)
+	}
+	return nil
+}
+
+// versionConstraint is a single comparison such as ">=1.0.0"
+type versionConstraint struct {
+	op      string
+	version *Version
+}
+
+func (c *versionConstraint) match(v *Version) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// versionRange matches when all of its constraints match
+type versionRange []*versionConstraint
+
+func (r versionRange) match(v *Version) bool {
+	for _, c := range r {
+		if !c.match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowListPolicy accepts peer versions matching any of the ranges, our own
+// version is not considered.
+type allowListPolicy struct {
+	ranges []versionRange
+	raw    string
+}
+
+// newAllowListPolicy parses ranges separated by "||", the constraints of one
+// range are separated by spaces or commas: ">=1.0.0 <1.2.0 || 1.3.0-rc1"
+func newAllowListPolicy(allowList string) (*allowListPolicy, error) {
+	p := &allowListPolicy{raw: allowList}
+	for _, part := range strings.Split(allowList, "||") {
+		fields := strings.FieldsFunc(part, func(r rune) bool { return r == ' ' || r == ',' })
+		if len(fields) == 0 {
+			continue
+		}
+
+		r := versionRange{}
+		for _, field := range fields {
+			c, err := parseVersionConstraint(field)
+			if err != nil {
+				return nil, errors.Wrap(err, "parse version allow list")
+			}
+			r = append(r, c)
+		}
+		p.ranges = append(p.ranges, r)
+	}
+	if len(p.ranges) == 0 {
+		return nil, errors.New("Empty version allow list")
+	}
+	return p, nil
+}
+
+func parseVersionConstraint(s string) (*versionConstraint, error) {
+	op := "="
+	for _, prefix := range []string{">=", "<=", "!=", ">", "<", "="} {
+		if strings.HasPrefix(s, prefix) {
+			op, s = prefix, s[len(prefix):]
+			break
+		}
+	}
+	v, err := ParseVersion(s)
+	if err != nil {
+		return nil, err
+	}
+	return &versionConstraint{op: op, version: v}, nil
+}
+
+func (p *allowListPolicy) Compatible(ours, theirs *Version) error {
+	for _, r := range p.ranges {
+		if r.match(theirs) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Peer version %v is not in the allow list %v", theirs, p.raw)
+}
@@ -0,0 +1,154 @@
+package p2p
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	cases := []struct {
+		in   string
+		want *Version
+	}{
+		{"1.2.3", &Version{Major: 1, Minor: 2, Patch: 3}},
+		{"v1.2.3", &Version{Major: 1, Minor: 2, Patch: 3}},
+		{" 01.002.3 ", &Version{Major: 1, Minor: 2, Patch: 3}},
+		{"1.0.0-rc.1", &Version{Major: 1, Prerelease: []string{"rc", "1"}}},
+		{"1.0.0+build.5", &Version{Major: 1, Build: []string{"build", "5"}}},
+		{"1.0.0-beta+exp.sha.5114f85", &Version{Major: 1, Prerelease: []string{"beta"}, Build: []string{"exp", "sha", "5114f85"}}},
+	}
+	for _, c := range cases {
+		got, err := ParseVersion(c.in)
+		if err != nil {
+			t.Errorf("ParseVersion(%q) error: %v", c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseVersion(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+
+	for _, in := range []string{"", "1.2", "1.2.3.4", "1.x.3", "1.2.3-", "1.2.3-rc..1", "1.2.3+", "1.2.3-r$c", "-1.2.3"} {
+		if v, err := ParseVersion(in); err == nil {
+			t.Errorf("ParseVersion(%q) = %v, want an error", in, v)
+		}
+	}
+}
+
+func TestNormalizeVersion(t *testing.T) {
+	cases := map[string]string{
+		"v01.0.3+abc": "1.0.3",
+		"1.0.3":       "1.0.3",
+		"1.0.3-rc.1":  "1.0.3-rc.1",
+		" garbage ":   "garbage",
+	}
+	for in, want := range cases {
+		if got := NormalizeVersion(in); got != want {
+			t.Errorf("NormalizeVersion(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// in increasing precedence, from the semver spec
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.1.0",
+		"2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := ParseVersion(ordered[i])
+			b, _ := ParseVersion(ordered[j])
+			want := compareUint(uint64(i), uint64(j))
+			if got := a.Compare(b); got != want {
+				t.Errorf("%v.Compare(%v) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+
+	a, _ := ParseVersion("1.0.0+a")
+	b, _ := ParseVersion("1.0.0+b")
+	if a.Compare(b) != 0 {
+		t.Error("build metadata must not change the precedence")
+	}
+}
+
+func TestVersionPolicies(t *testing.T) {
+	cases := []struct {
+		policy    string
+		allowList string
+		ours      string
+		theirs    string
+		ok        bool
+	}{
+		{VersionPolicyExactMinor, "", "1.2.3", "1.2.9", true},
+		{VersionPolicyExactMinor, "", "1.2.3", "1.3.0", false},
+		{VersionPolicyExactMinor, "", "1.2.3", "2.2.3", false},
+		{"", "", "1.2.3", "1.2.0", true},
+		{VersionPolicySameMajor, "", "1.2.3", "1.9.0", true},
+		{VersionPolicySameMajor, "", "1.2.3", "2.0.0", false},
+		{VersionPolicyAllowList, ">=1.0.0 <1.2.0", "9.9.9", "1.1.5", true},
+		{VersionPolicyAllowList, ">=1.0.0 <1.2.0", "9.9.9", "1.2.0", false},
+		{VersionPolicyAllowList, ">=1.0.0,<1.2.0 || 1.3.0-rc1", "9.9.9", "1.3.0-rc1", true},
+		{VersionPolicyAllowList, ">=1.0.0,<1.2.0 || 1.3.0-rc1", "9.9.9", "1.3.0", false},
+		{VersionPolicyAllowList, "!=1.0.1", "1.0.0", "1.0.1", false},
+		{VersionPolicyAllowList, ">1.0.0 <=1.0.2", "1.0.0", "1.0.2", true},
+		{VersionPolicyAllowList, ">1.0.0 <=1.0.2", "1.0.0", "1.0.0", false},
+	}
+	for _, c := range cases {
+		policy, err := NewVersionPolicy(c.policy, c.allowList)
+		if err != nil {
+			t.Fatalf("NewVersionPolicy(%q, %q) error: %v", c.policy, c.allowList, err)
+		}
+		ours, _ := ParseVersion(c.ours)
+		theirs, _ := ParseVersion(c.theirs)
+		if err := policy.Compatible(ours, theirs); (err == nil) != c.ok {
+			t.Errorf("%s %q: Compatible(%v, %v) = %v, want ok %v", c.policy, c.allowList, ours, theirs, err, c.ok)
+		}
+	}
+}
+
+func TestNewVersionPolicyErrors(t *testing.T) {
+	cases := []struct {
+		policy    string
+		allowList string
+	}{
+		{"unknown", ""},
+		{VersionPolicyAllowList, ""},
+		{VersionPolicyAllowList, " || , "},
+		{VersionPolicyAllowList, ">=1.0"},
+		{VersionPolicyAllowList, "~1.0.0"},
+	}
+	for _, c := range cases {
+		if _, err := NewVersionPolicy(c.policy, c.allowList); err == nil {
+			t.Errorf("NewVersionPolicy(%q, %q) succeeded, want an error", c.policy, c.allowList)
+		}
+	}
+}
+
+func TestCompatibleWith(t *testing.T) {
+	ours := &NodeInfo{Network: "mainnet", Version: "1.2.3"}
+	cases := []struct {
+		other *NodeInfo
+		ok    bool
+	}{
+		{&NodeInfo{Network: "mainnet", Version: "v1.2.0+abc"}, true},
+		{&NodeInfo{Network: "testnet", Version: "1.2.3"}, false},
+		{&NodeInfo{Network: "mainnet", Version: "1.3.0"}, false},
+		{&NodeInfo{Network: "mainnet", Version: "bad"}, false},
+	}
+	for _, c := range cases {
+		if err := ours.CompatibleWith(c.other, exactMinorPolicy{}); (err == nil) != c.ok {
+			t.Errorf("CompatibleWith(%+v) = %v, want ok %v", c.other, err, c.ok)
+		}
+	}
+}
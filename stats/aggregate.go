@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"net"
+	"time"
+
+	"github.com/nodestats/geoip"
+	"github.com/nodestats/p2p"
+)
+
+// Summary aggregates the census by version, network, IP group and location.
+type Summary struct {
+	Since     time.Time       `json:"since"`
+	Total     int             `json:"total"`
+	ByVersion map[string]int  `json:"by_version"`
+	ByNetwork map[string]int  `json:"by_network"`
+	ByIPGroup map[string]int  `json:"by_ip_group"`
+	ByCountry map[string]int  `json:"by_country"`
+	ByASN     map[string]int  `json:"by_asn"`    // keyed by "AS<number> <organisation>"
+	Unlocated int             `json:"unlocated"` // nodes whose IP wasn't located yet
+	Hosting   *HostingSummary `json:"hosting"`
+}
+
+// GroupKeyFunc maps an IP to its network group
+type GroupKeyFunc func(ip net.IP) string
+
+// Aggregate summarizes every node last seen after since. A node is counted
+// with its latest NodeInfo and the IP it was most recently seen at. A nil
+// groupKey leaves ByIPGroup empty.
+func Aggregate(nodes []*NodeRecord, since time.Time, groupKey GroupKeyFunc) *Summary {
+	summary := &Summary{
+		Since:     since,
+		ByVersion: make(map[string]int),
+		ByNetwork: make(map[string]int),
+		ByIPGroup: make(map[string]int),
+		ByCountry: make(map[string]int),
+		ByASN:     make(map[string]int),
+		Hosting:   &HostingSummary{ByProvider: make(map[string]int)},
+	}
+	locations := []*geoip.Location{}
+
+	for _, node := range nodes {
+		if node.LastSeen.Before(since) {
+			continue
+		}
+
+		summary.Total++
+		if info := node.LatestInfo(); info != nil {
+			summary.ByVersion[p2p.NormalizeVersion(info.Version)]++
+			summary.ByNetwork[info.Network]++
+		}
+		if latest := node.latestIPRecord(); latest != nil {
+			if ip := net.ParseIP(latest.IP); ip != nil && groupKey != nil {
+				summary.ByIPGroup[groupKey(ip)]++
+			}
+			locations = append(locations, latest.Geo)
+		}
+	}
+	summary.aggregateGeo(locations)
+	return summary
+}
+
+// LatestIP returns the IP the node was most recently seen at
+func (n *NodeRecord) LatestIP() net.IP {
+	latest := n.latestIPRecord()
+	if latest == nil {
+		return nil
+	}
+	return net.ParseIP(latest.IP)
+}
+
+func (n *NodeRecord) latestIPRecord() *IPRecord {
+	var latest *IPRecord
+	for _, r := range n.IPs {
+		if latest == nil || r.LastSeen.After(latest.LastSeen) {
+			latest = r
+		}
+	}
+	return latest
+}
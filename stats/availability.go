@@ -0,0 +1,106 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// AvailabilityWindows are the time constants availability is computed over,
+// the same ones the bitcoin seeder uses.
+var AvailabilityWindows = []struct {
+	Name string
+	Tau  time.Duration
+}{
+	{"2h", 2 * time.Hour},
+	{"8h", 8 * time.Hour},
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// AvailabilityStat is a success ratio where older dials weigh exponentially less
+type AvailabilityStat struct {
+	Reliability float64 `json:"reliability"` // decayed sum of successes
+	Weight      float64 `json:"weight"`      // decayed sum of attempts
+	Count       float64 `json:"count"`       // decayed number of attempts
+}
+
+// Availability is the decayed success ratio of an address per window name
+type Availability map[string]*AvailabilityStat
+
+// update decays the previous value by the time since the last attempt, age,
+// then accounts for the new attempt.
+func (s *AvailabilityStat) update(good bool, age, tau time.Duration) {
+	f := math.Exp(-float64(age) / float64(tau))
+	s.Reliability = s.Reliability * f
+	if good {
+		s.Reliability += 1 - f
+	}
+	s.Count = s.Count*f + 1
+	s.Weight = s.Weight*f + (1 - f)
+}
+
+// Score is the ratio of successful dials in the window, between 0 and 1
+func (s *AvailabilityStat) Score() float64 {
+	if s == nil || s.Weight == 0 {
+		return 0
+	}
+	return s.Reliability / s.Weight
+}
+
+func (a Availability) update(good bool, age time.Duration) {
+	for _, w := range AvailabilityWindows {
+		stat, ok := a[w.Name]
+		if !ok {
+			stat = &AvailabilityStat{}
+			a[w.Name] = stat
+		}
+		stat.update(good, age, w.Tau)
+	}
+}
+
+// Score returns the score of the window, 0 for an unknown window
+func (a Availability) Score(window string) float64 {
+	return a[window].Score()
+}
+
+// ValidAvailabilityWindow returns an error unless window is one of AvailabilityWindows
+func ValidAvailabilityWindow(window string) error {
+	for _, w := range AvailabilityWindows {
+		if w.Name == window {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown availability window %s", window)
+}
+
+// recordAttempt updates the availability with a dial made at now
+func (a *AddrRecord) recordAttempt(good bool, now time.Time) {
+	if a.Availability == nil {
+		a.Availability = make(Availability)
+	}
+
+	// the first attempt has nothing to decay, it sets the score on its own
+	age := time.Duration(math.MaxInt64)
+	if !a.LastAttempt.IsZero() {
+		age = now.Sub(a.LastAttempt)
+	}
+	a.Availability.update(good, age)
+}
+
+// RankByAvailability sorts the records by their score in window, best first.
+// Records with fewer than minAttempts decayed attempts in the window are dropped.
+func RankByAvailability(addrs []*AddrRecord, window string, minAttempts float64) []*AddrRecord {
+	ranked := []*AddrRecord{}
+	for _, addr := range addrs {
+		if stat := addr.Availability[window]; stat != nil && stat.Count >= minAttempts {
+			ranked = append(ranked, addr)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Availability.Score(window) > ranked[j].Availability.Score(window)
+	})
+	return ranked
+}
@@ -0,0 +1,129 @@
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+type testAttempt struct {
+	offset time.Duration // since the first attempt
+	good   bool
+}
+
+func recordAttempts(attempts []testAttempt) *AddrRecord {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	record := &AddrRecord{}
+	for _, a := range attempts {
+		now := start.Add(a.offset)
+		record.recordAttempt(a.good, now)
+		record.LastAttempt = now
+	}
+	return record
+}
+
+func TestAvailabilityScore(t *testing.T) {
+	cases := []struct {
+		name     string
+		attempts []testAttempt
+		scores   map[string]float64
+		count1d  float64
+	}{
+		{
+			name:     "first success",
+			attempts: []testAttempt{{0, true}},
+			scores:   map[string]float64{"2h": 1, "1d": 1, "30d": 1},
+			count1d:  1,
+		},
+		{
+			name:     "first failure",
+			attempts: []testAttempt{{0, false}},
+			scores:   map[string]float64{"2h": 0, "1d": 0, "30d": 0},
+			count1d:  1,
+		},
+		{
+			// the success decays by e^(-1) in the 1d window
+			name:     "success then failure a day later",
+			attempts: []testAttempt{{0, true}, {24 * time.Hour, false}},
+			scores:   map[string]float64{"2h": 0.000006, "1d": 0.367879, "30d": 0.967216},
+			count1d:  1.367879,
+		},
+		{
+			name:     "failure then success a day later",
+			attempts: []testAttempt{{0, false}, {24 * time.Hour, true}},
+			scores:   map[string]float64{"2h": 0.999994, "1d": 0.632121, "30d": 0.032784},
+			count1d:  1.367879,
+		},
+		{
+			name:     "success then hourly failures",
+			attempts: []testAttempt{{0, true}, {time.Hour, false}, {2 * time.Hour, false}},
+			scores:   map[string]float64{"2h": 0.367879, "1d": 0.920044, "30d": 0.997226},
+			count1d:  2.879233,
+		},
+		{
+			// no time passed, the second attempt weighs nothing
+			name:     "simultaneous attempts",
+			attempts: []testAttempt{{0, true}, {0, false}},
+			scores:   map[string]float64{"2h": 1, "1d": 1, "30d": 1},
+			count1d:  2,
+		},
+	}
+
+	for _, c := range cases {
+		record := recordAttempts(c.attempts)
+		for window, expected := range c.scores {
+			if score := record.Availability.Score(window); math.Abs(score-expected) > 1e-6 {
+				t.Errorf("%s: expected %s score %f, got %f", c.name, window, expected, score)
+			}
+		}
+		if count := record.Availability["1d"].Count; math.Abs(count-c.count1d) > 1e-6 {
+			t.Errorf("%s: expected 1d count %f, got %f", c.name, c.count1d, count)
+		}
+	}
+}
+
+func TestAvailabilityWindows(t *testing.T) {
+	for _, w := range AvailabilityWindows {
+		if err := ValidAvailabilityWindow(w.Name); err != nil {
+			t.Errorf("%s: %v", w.Name, err)
+		}
+	}
+	if err := ValidAvailabilityWindow("24h"); err == nil {
+		t.Error("expected 24h to be invalid")
+	}
+
+	record := recordAttempts([]testAttempt{{0, true}})
+	if score := record.Availability.Score("24h"); score != 0 {
+		t.Errorf("expected 0 for an unknown window, got %f", score)
+	}
+}
+
+func TestRankByAvailability(t *testing.T) {
+	good := recordAttempts([]testAttempt{{0, true}, {time.Hour, true}})
+	good.Addr = "good"
+	bad := recordAttempts([]testAttempt{{0, false}, {time.Hour, false}})
+	bad.Addr = "bad"
+	mixed := recordAttempts([]testAttempt{{0, true}, {time.Hour, false}})
+	mixed.Addr = "mixed"
+	once := recordAttempts([]testAttempt{{0, true}})
+	once.Addr = "once"
+	never := &AddrRecord{Addr: "never"}
+
+	ranked := RankByAvailability([]*AddrRecord{bad, never, mixed, good}, "1d", 0)
+	if len(ranked) != 3 || ranked[0] != good || ranked[1] != mixed || ranked[2] != bad {
+		t.Errorf("unexpected order %v", addrsOf(ranked))
+	}
+
+	ranked = RankByAvailability([]*AddrRecord{once, bad, good}, "1d", 1.5)
+	if len(ranked) != 2 || ranked[0] != good || ranked[1] != bad {
+		t.Errorf("expected the address dialed once to be left out, got %v", addrsOf(ranked))
+	}
+}
+
+func addrsOf(records []*AddrRecord) []string {
+	addrs := make([]string, len(records))
+	for i, r := range records {
+		addrs[i] = r.Addr
+	}
+	return addrs
+}
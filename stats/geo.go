@@ -0,0 +1,162 @@
+package stats
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nodestats/geoip"
+)
+
+// hostingProviders maps a provider to substrings of the organisation names of
+// its autonomous systems, matched case insensitively.
+var hostingProviders = []struct {
+	Name string
+	Orgs []string
+}{
+	{"Amazon", []string{"amazon"}},
+	{"Google", []string{"google"}},
+	{"Microsoft", []string{"microsoft"}},
+	{"Alibaba", []string{"alibaba"}},
+	{"Tencent", []string{"tencent"}},
+	{"Oracle", []string{"oracle"}},
+	{"DigitalOcean", []string{"digitalocean"}},
+	{"Hetzner", []string{"hetzner"}},
+	{"OVH", []string{"ovh"}},
+	{"Linode", []string{"linode", "akamai"}},
+	{"Vultr", []string{"choopa", "vultr"}},
+	{"Contabo", []string{"contabo"}},
+	{"Scaleway", []string{"scaleway", "online s.a.s"}},
+	{"Leaseweb", []string{"leaseweb"}},
+	{"IONOS", []string{"ionos", "1&1"}},
+}
+
+// HostingProvider returns the hosting provider owning the autonomous system
+// of organisation org, "" for residential and unknown networks.
+func HostingProvider(org string) string {
+	org = strings.ToLower(org)
+	for _, p := range hostingProviders {
+		for _, o := range p.Orgs {
+			if strings.Contains(org, o) {
+				return p.Name
+			}
+		}
+	}
+	return ""
+}
+
+// HostingSummary measures how concentrated the nodes are in a few networks.
+type HostingSummary struct {
+	Nodes      int            `json:"nodes"`   // nodes in a hosting provider
+	Percent    float64        `json:"percent"` // of the located nodes
+	ByProvider map[string]int `json:"by_provider"`
+	TopASN     string         `json:"top_asn,omitempty"`
+	TopPercent float64        `json:"top_percent"` // nodes in the largest ASN
+	// HHI is the Herfindahl-Hirschman index of the ASN shares, from near 0
+	// for nodes spread over many networks to 10000 for a single network.
+	HHI float64 `json:"hhi"`
+}
+
+func asnKey(loc *geoip.Location) string {
+	return fmt.Sprintf("AS%d %s", loc.ASN, loc.Org)
+}
+
+// aggregateGeo fills the geographic and network breakdowns of the summary
+// from the location of every counted node, nil when it was never located.
+func (summary *Summary) aggregateGeo(locations []*geoip.Location) {
+	located := 0
+	for _, loc := range locations {
+		if loc == nil {
+			summary.Unlocated++
+			continue
+		}
+		located++
+		if loc.Country != "" {
+			summary.ByCountry[loc.Country]++
+		}
+		if loc.ASN != 0 {
+			summary.ByASN[asnKey(loc)]++
+			if provider := HostingProvider(loc.Org); provider != "" {
+				summary.Hosting.Nodes++
+				summary.Hosting.ByProvider[provider]++
+			}
+		}
+	}
+
+	hosting := summary.Hosting
+	hosting.Percent = percent(hosting.Nodes, located)
+	for asn, n := range summary.ByASN {
+		share := percent(n, located)
+		hosting.HHI += share * share
+		if share > hosting.TopPercent || (share == hosting.TopPercent && asn < hosting.TopASN) {
+			hosting.TopASN, hosting.TopPercent = asn, share
+		}
+	}
+}
+
+// enrich looks up the IPs without a location, every IP when force is set.
+// It returns the number of IPs located.
+func (n *NodeRecord) enrich(resolver *geoip.Resolver, force bool) int {
+	located := 0
+	for _, r := range n.IPs {
+		if r.Geo != nil && !force {
+			continue
+		}
+		ip := net.ParseIP(r.IP)
+		if ip == nil {
+			continue
+		}
+		loc, err := resolver.Lookup(ip)
+		if err != nil {
+			log.WithFields(log.Fields{"ip": r.IP, "err": err}).Warn("geoip lookup failed")
+			continue
+		}
+		r.Geo = loc
+		located++
+	}
+	return located
+}
+
+// SetGeoIP makes the store locate the IPs of the nodes as they are recorded
+func (s *Store) SetGeoIP(resolver *geoip.Resolver) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.geo = resolver
+}
+
+// Enrich locates the IPs recorded without a location, or every IP when force
+// is set to pick up a newer database. It returns the number of IPs located.
+func (s *Store) Enrich(resolver *geoip.Resolver, force bool) (int, error) {
+	nodes, err := s.ListNodes()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, listed := range nodes {
+		n, err := s.enrichNode(listed.PubKey, resolver, force)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (s *Store) enrichNode(pubKey string, resolver *geoip.Resolver, force bool) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// read it again, the node may have been updated since it was listed
+	node, err := s.getNode(pubKey)
+	if err != nil {
+		return 0, err
+	}
+	n := node.enrich(resolver, force)
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveNode(node)
+}
@@ -0,0 +1,163 @@
+package stats
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/nodestats/geoip"
+	"github.com/nodestats/geoip/geoiptest"
+)
+
+func TestHostingProvider(t *testing.T) {
+	cases := map[string]string{
+		"AMAZON-02":              "Amazon",
+		"Hetzner Online GmbH":    "Hetzner",
+		"Akamai Connected Cloud": "Linode",
+		"Choopa, LLC":            "Vultr",
+		"1&1 IONOS SE":           "IONOS",
+		"Deutsche Telekom AG":    "",
+		"":                       "",
+	}
+	for org, expected := range cases {
+		if provider := HostingProvider(org); provider != expected {
+			t.Errorf("%q: expected %q, got %q", org, expected, provider)
+		}
+	}
+}
+
+func newGeoSummary() *Summary {
+	return &Summary{
+		ByCountry: make(map[string]int),
+		ByASN:     make(map[string]int),
+		Hosting:   &HostingSummary{ByProvider: make(map[string]int)},
+	}
+}
+
+func TestAggregateGeo(t *testing.T) {
+	hetzner := &geoip.Location{Country: "DE", ASN: 24940, Org: "Hetzner Online GmbH"}
+	amazon := &geoip.Location{Country: "US", ASN: 16509, Org: "AMAZON-02"}
+	telekom := &geoip.Location{Country: "DE", ASN: 3320, Org: "Deutsche Telekom AG"}
+	countryOnly := &geoip.Location{Country: "AU"}
+
+	summary := newGeoSummary()
+	summary.aggregateGeo([]*geoip.Location{hetzner, nil, amazon, hetzner, telekom, countryOnly})
+
+	if summary.Unlocated != 1 {
+		t.Errorf("expected 1 unlocated node, got %d", summary.Unlocated)
+	}
+	if summary.ByCountry["DE"] != 3 || summary.ByCountry["US"] != 1 || summary.ByCountry["AU"] != 1 {
+		t.Errorf("unexpected countries %v", summary.ByCountry)
+	}
+	if len(summary.ByASN) != 3 || summary.ByASN["AS24940 Hetzner Online GmbH"] != 2 {
+		t.Errorf("unexpected ASNs %v", summary.ByASN)
+	}
+
+	hosting := summary.Hosting
+	if hosting.Nodes != 3 || hosting.ByProvider["Hetzner"] != 2 || hosting.ByProvider["Amazon"] != 1 {
+		t.Errorf("unexpected hosting providers %d %v", hosting.Nodes, hosting.ByProvider)
+	}
+	// shares of the 5 located nodes: 40% + 20% + 20%, the one without ASN counts as located
+	if hosting.Percent != 60 {
+		t.Errorf("expected 60%% in hosting providers, got %f", hosting.Percent)
+	}
+	if hosting.TopASN != "AS24940 Hetzner Online GmbH" || hosting.TopPercent != 40 {
+		t.Errorf("unexpected top ASN %s with %f%%", hosting.TopASN, hosting.TopPercent)
+	}
+	if math.Abs(hosting.HHI-2400) > 1e-9 {
+		t.Errorf("expected HHI 2400, got %f", hosting.HHI)
+	}
+}
+
+func TestAggregateGeoTopASNTie(t *testing.T) {
+	summary := newGeoSummary()
+	summary.aggregateGeo([]*geoip.Location{{ASN: 2, Org: "b"}, {ASN: 1, Org: "a"}})
+
+	if summary.Hosting.TopASN != "AS1 a" || summary.Hosting.TopPercent != 50 {
+		t.Errorf("expected the smallest key to win a tie, got %s with %f%%", summary.Hosting.TopASN, summary.Hosting.TopPercent)
+	}
+	if summary.Hosting.HHI != 5000 {
+		t.Errorf("expected HHI 5000, got %f", summary.Hosting.HHI)
+	}
+
+	empty := newGeoSummary()
+	empty.aggregateGeo(nil)
+	if empty.Hosting.Percent != 0 || empty.Hosting.HHI != 0 || empty.Hosting.TopASN != "" {
+		t.Errorf("expected an empty hosting summary, got %+v", empty.Hosting)
+	}
+}
+
+func testResolver(t *testing.T) (*geoip.Resolver, func()) {
+	dir, err := ioutil.TempDir("", "geo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "city.mmdb")
+	err = geoiptest.WriteDB(path, "GeoLite2-City", map[string]map[string]interface{}{
+		"5.9.0.0/16": geoiptest.CityRecord("DE", "Falkenstein"),
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	resolver, err := geoip.Open(path, "")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return resolver, func() {
+		resolver.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestStoreEnrich(t *testing.T) {
+	resolver, cleanup := testResolver(t)
+	defer cleanup()
+
+	store := NewStore(dbm.NewMemDB())
+	stale := &geoip.Location{Country: "FR"}
+	err := store.saveNode(&NodeRecord{PubKey: "aa", IPs: []*IPRecord{
+		{IP: "5.9.1.1", Geo: stale}, // located with an older database
+		{IP: "5.9.2.2"},
+		{IP: "not an ip"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	located, err := store.Enrich(resolver, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if located != 1 {
+		t.Errorf("expected only the IP without location to be looked up, got %d", located)
+	}
+	node, err := store.GetNode("aa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *node.IPs[0].Geo != *stale || node.IPs[1].Geo == nil || node.IPs[1].Geo.City != "Falkenstein" || node.IPs[2].Geo != nil {
+		t.Errorf("unexpected locations %+v %+v %+v", node.IPs[0].Geo, node.IPs[1].Geo, node.IPs[2].Geo)
+	}
+
+	if located, _ := store.Enrich(resolver, false); located != 0 {
+		t.Errorf("expected located IPs to be skipped, got %d", located)
+	}
+
+	located, err = store.Enrich(resolver, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if located != 2 {
+		t.Errorf("expected both IPs to be looked up again, got %d", located)
+	}
+	node, _ = store.GetNode("aa")
+	if node.IPs[0].Geo.Country != "DE" {
+		t.Errorf("expected the stale location to be replaced, got %+v", node.IPs[0].Geo)
+	}
+}
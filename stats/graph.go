@@ -0,0 +1,241 @@
+package stats
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// graph export formats
+const (
+	GraphFormatDOT  = "dot"
+	GraphFormatGEXF = "gexf"
+	GraphFormatJSON = "json"
+)
+
+// ErrUnknownGraphFormat is returned when exporting to an unsupported format
+var ErrUnknownGraphFormat = errors.New("unknown graph format, use dot, gexf or json")
+
+// GraphNode is a vertex of the topology graph. Addresses resolved to a known
+// node use its pubkey as ID, the others use the address.
+type GraphNode struct {
+	ID        string `json:"id"`
+	Label     string `json:"label"`
+	Version   string `json:"version,omitempty"`
+	Responded bool   `json:"responded"` // the node answered a PEX request
+}
+
+// GraphEdge means From advertised To in a PEX response
+type GraphEdge struct {
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Graph is the directed "knows about" graph built from PEX responses
+type Graph struct {
+	Nodes []*GraphNode `json:"nodes"`
+	Edges []*GraphEdge `json:"edges"`
+}
+
+// BuildGraph builds the topology graph from the addresses advertised after since
+func BuildGraph(adverts []*AdvertRecord, nodes []*NodeRecord, since time.Time) *Graph {
+	byAddr := make(map[string]*NodeRecord)
+	byKey := make(map[string]*NodeRecord)
+	for _, node := range nodes {
+		byKey[node.PubKey] = node
+		if info := node.LatestInfo(); info != nil && info.ListenAddr != "" {
+			byAddr[info.ListenAddr] = node
+		}
+	}
+
+	vertices := make(map[string]*GraphNode)
+	vertex := func(id string, node *NodeRecord) *GraphNode {
+		if v, ok := vertices[id]; ok {
+			return v
+		}
+		v := &GraphNode{ID: id, Label: id}
+		if node != nil {
+			if info := node.LatestInfo(); info != nil {
+				v.Label, v.Version = info.Moniker, info.Version
+			}
+		}
+		vertices[id] = v
+		return v
+	}
+
+	graph := &Graph{Nodes: []*GraphNode{}, Edges: []*GraphEdge{}}
+	for _, advert := range adverts {
+		if advert.LastSeen.Before(since) {
+			continue
+		}
+
+		from := vertex(advert.PubKey, byKey[advert.PubKey])
+		from.Responded = true
+		for _, addr := range advert.Addrs {
+			if addr.LastSeen.Before(since) {
+				continue
+			}
+
+			to := addr.Addr
+			if node, ok := byAddr[addr.Addr]; ok {
+				to = node.PubKey
+			}
+			if to == from.ID {
+				continue
+			}
+			vertex(to, byAddr[addr.Addr])
+			graph.Edges = append(graph.Edges, &GraphEdge{From: from.ID, To: to, LastSeen: addr.LastSeen})
+		}
+	}
+
+	for _, v := range vertices {
+		graph.Nodes = append(graph.Nodes, v)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].ID < graph.Nodes[j].ID })
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		return graph.Edges[i].To < graph.Edges[j].To
+	})
+	return graph
+}
+
+// Write exports the graph in the given format
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case GraphFormatDOT:
+		return g.WriteDOT(w)
+	case GraphFormatGEXF:
+		return g.WriteGEXF(w)
+	case GraphFormatJSON:
+		return json.NewEncoder(w).Encode(g.Adjacency())
+	}
+	return ErrUnknownGraphFormat
+}
+
+// Adjacency returns the graph as an adjacency list, every node is a key
+func (g *Graph) Adjacency() map[string][]string {
+	adjacency := make(map[string][]string)
+	for _, n := range g.Nodes {
+		adjacency[n.ID] = []string{}
+	}
+	for _, e := range g.Edges {
+		adjacency[e.From] = append(adjacency[e.From], e.To)
+	}
+	return adjacency
+}
+
+// WriteDOT writes the graph in GraphViz DOT format
+func (g *Graph) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph nodestats {"); err != nil {
+		return err
+	}
+	for _, n := range g.Nodes {
+		shape := "ellipse"
+		if n.Responded {
+			shape = "box"
+		}
+		if _, err := fmt.Fprintf(w, "\t%s [label=%s, shape=%s];\n", strconv.Quote(n.ID), strconv.Quote(n.Label), shape); err != nil {
+			return err
+		}
+	}
+	for _, e := range g.Edges {
+		if _, err := fmt.Fprintf(w, "\t%s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To)); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+type gexfDoc struct {
+	XMLName xml.Name  `xml:"gexf"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfGraph struct {
+	DefaultEdgeType string         `xml:"defaultedgetype,attr"`
+	Attributes      gexfAttributes `xml:"attributes"`
+	Nodes           []*gexfNode    `xml:"nodes>node"`
+	Edges           []*gexfEdge    `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string           `xml:"class,attr"`
+	Attributes []*gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID        string          `xml:"id,attr"`
+	Label     string          `xml:"label,attr"`
+	AttValues []*gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+// WriteGEXF writes the graph in GEXF 1.2 format, readable by Gephi
+func (g *Graph) WriteGEXF(w io.Writer) error {
+	doc := &gexfDoc{
+		Xmlns:   "http://www.gexf.net/1.2draft",
+		Version: "1.2",
+		Graph: gexfGraph{
+			DefaultEdgeType: "directed",
+			Attributes: gexfAttributes{
+				Class: "node",
+				Attributes: []*gexfAttribute{
+					{ID: "version", Title: "version", Type: "string"},
+					{ID: "responded", Title: "responded", Type: "boolean"},
+				},
+			},
+		},
+	}
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, &gexfNode{
+			ID:    n.ID,
+			Label: n.Label,
+			AttValues: []*gexfAttValue{
+				{For: "version", Value: n.Version},
+				{For: "responded", Value: strconv.FormatBool(n.Responded)},
+			},
+		})
+	}
+	for i, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, &gexfEdge{ID: strconv.Itoa(i), Source: e.From, Target: e.To})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
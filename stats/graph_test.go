@@ -0,0 +1,135 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testGraph() *Graph {
+	now := time.Now()
+	nodes := []*NodeRecord{
+		{PubKey: "aa", Infos: []*InfoRevision{{Moniker: "alice", Version: "1.0.0", ListenAddr: "1.1.1.1:46656"}}},
+		{PubKey: "bb", Infos: []*InfoRevision{{Moniker: "bob", Version: "1.1.0", ListenAddr: "2.2.2.2:46656"}}},
+	}
+	adverts := []*AdvertRecord{
+		{PubKey: "aa", LastSeen: now, Addrs: []*AdvertisedAddr{
+			{Addr: "2.2.2.2:46656", LastSeen: now},
+			{Addr: "3.3.3.3:46656", LastSeen: now},
+			{Addr: "1.1.1.1:46656", LastSeen: now},                 // itself
+			{Addr: "4.4.4.4:46656", LastSeen: now.Add(-time.Hour)}, // stale
+		}},
+		{PubKey: "cc", LastSeen: now.Add(-time.Hour), Addrs: []*AdvertisedAddr{
+			{Addr: "1.1.1.1:46656", LastSeen: now.Add(-time.Hour)},
+		}},
+	}
+	return BuildGraph(adverts, nodes, now.Add(-time.Minute))
+}
+
+func TestBuildGraph(t *testing.T) {
+	g := testGraph()
+
+	expectedNodes := []GraphNode{
+		{ID: "3.3.3.3:46656", Label: "3.3.3.3:46656"},
+		{ID: "aa", Label: "alice", Version: "1.0.0", Responded: true},
+		{ID: "bb", Label: "bob", Version: "1.1.0"},
+	}
+	if len(g.Nodes) != len(expectedNodes) {
+		t.Fatalf("expected %d nodes, got %d", len(expectedNodes), len(g.Nodes))
+	}
+	for i, n := range g.Nodes {
+		if *n != expectedNodes[i] {
+			t.Errorf("node %d: expected %+v, got %+v", i, expectedNodes[i], *n)
+		}
+	}
+
+	if len(g.Edges) != 2 {
+		t.Fatalf("expected 2 edges, got %d", len(g.Edges))
+	}
+	if e := g.Edges[0]; e.From != "aa" || e.To != "3.3.3.3:46656" {
+		t.Errorf("unexpected edge %s -> %s", e.From, e.To)
+	}
+	if e := g.Edges[1]; e.From != "aa" || e.To != "bb" {
+		t.Errorf("unexpected edge %s -> %s", e.From, e.To)
+	}
+}
+
+func TestGraphAdjacency(t *testing.T) {
+	g := testGraph()
+
+	expected := map[string][]string{
+		"3.3.3.3:46656": {},
+		"aa":            {"3.3.3.3:46656", "bb"},
+		"bb":            {},
+	}
+	if adjacency := g.Adjacency(); !reflect.DeepEqual(adjacency, expected) {
+		t.Errorf("expected %v, got %v", expected, adjacency)
+	}
+
+	var buf bytes.Buffer
+	if err := g.Write(&buf, GraphFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	decoded := map[string][]string{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("expected %v, got %v", expected, decoded)
+	}
+}
+
+func TestGraphWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testGraph().Write(&buf, GraphFormatDOT); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"digraph nodestats {",
+		"\t\"3.3.3.3:46656\" [label=\"3.3.3.3:46656\", shape=ellipse];",
+		"\t\"aa\" [label=\"alice\", shape=box];",
+		"\t\"bb\" [label=\"bob\", shape=ellipse];",
+		"\t\"aa\" -> \"3.3.3.3:46656\";",
+		"\t\"aa\" -> \"bb\";",
+		"}",
+		"",
+	}, "\n")
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestGraphWriteGEXF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testGraph().Write(&buf, GraphFormatGEXF); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := &gexfDoc{}
+	if err := xml.Unmarshal(buf.Bytes(), doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != "1.2" || doc.Graph.DefaultEdgeType != "directed" {
+		t.Errorf("unexpected header: version %q, edge type %q", doc.Version, doc.Graph.DefaultEdgeType)
+	}
+	if len(doc.Graph.Nodes) != 3 || len(doc.Graph.Edges) != 2 {
+		t.Fatalf("expected 3 nodes and 2 edges, got %d and %d", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+	if n := doc.Graph.Nodes[1]; n.ID != "aa" || n.Label != "alice" || n.AttValues[1].Value != "true" {
+		t.Errorf("unexpected node %+v", n)
+	}
+	if e := doc.Graph.Edges[1]; e.ID != "1" || e.Source != "aa" || e.Target != "bb" {
+		t.Errorf("unexpected edge %+v", e)
+	}
+}
+
+func TestGraphWriteUnknownFormat(t *testing.T) {
+	if err := testGraph().Write(&bytes.Buffer{}, "svg"); err != ErrUnknownGraphFormat {
+		t.Errorf("expected ErrUnknownGraphFormat, got %v", err)
+	}
+}
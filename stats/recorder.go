@@ -0,0 +1,125 @@
+package stats
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	cmn "github.com/tendermint/tmlibs/common"
+
+	"github.com/nodestats/p2p"
+)
+
+const (
+	recorderName     = "StatsRecorder"
+	recorderCapacity = 16384
+)
+
+// Recorder subscribes to the switch peer events and writes every peer the
+// switch connects to and every dial result into the node store.
+type Recorder struct {
+	cmn.BaseService
+
+	sw    *p2p.Switch
+	store *Store
+	done  chan struct{}
+}
+
+// NewRecorder creates a new stats recorder
+func NewRecorder(sw *p2p.Switch, store *Store) *Recorder {
+	r := &Recorder{
+		sw:    sw,
+		store: store,
+	}
+	r.BaseService = *cmn.NewBaseService(nil, recorderName, r)
+	return r
+}
+
+// OnStart implements BaseService. Rejections are stored by a handler, before
+// the switch closes the rejected connection, everything else goes through a
+// buffered channel so the store writes never stall the switch.
+func (r *Recorder) OnStart() error {
+	r.BaseService.OnStart()
+	r.done = make(chan struct{})
+	go r.eventRoutine(r.sw.Subscribe(recorderName, recorderCapacity))
+	r.sw.SubscribeFunc(recorderName, r.handleRejection)
+	return nil
+}
+
+// OnStop implements BaseService
+func (r *Recorder) OnStop() {
+	r.BaseService.OnStop()
+	r.sw.Unsubscribe(recorderName)
+	<-r.done
+}
+
+// eventRoutine consumes events until the subscription is closed
+func (r *Recorder) eventRoutine(events <-chan *p2p.Event) {
+	defer close(r.done)
+	for event := range events {
+		if event.Type != p2p.EventHandshakeRejected {
+			r.handle(event)
+		}
+	}
+}
+
+// handleRejection is called by the switch in the handshake goroutine, only
+// rejections are written there
+func (r *Recorder) handleRejection(event *p2p.Event) {
+	if event.Type == p2p.EventHandshakeRejected {
+		r.handle(event)
+	}
+}
+
+func (r *Recorder) handle(event *p2p.Event) {
+	if err := r.record(event); err != nil {
+		log.WithFields(log.Fields{"event": event.Type, "address": event.Addr, "err": err}).Error("fail to record peer event")
+	}
+}
+
+func (r *Recorder) record(event *p2p.Event) error {
+	switch event.Type {
+	case p2p.EventHandshakeCompleted:
+		if event.Outbound && event.Addr != nil {
+			return r.store.RecordReachability(event.Addr, nil, event.Time)
+		}
+	case p2p.EventDialFailed:
+		// a node rejected as incompatible still answered the handshake
+		if event.NodeInfo != nil {
+			return r.store.RecordReachability(event.Addr, nil, event.Time)
+		}
+		// only failures to reach the address count, refusals on our side
+		// and rejections after the handshake don't
+		switch p2p.DialErrorClass(event.Err) {
+		case "timeout", "connect", "handshake":
+			return r.store.RecordReachability(event.Addr, event.Err, event.Time)
+		}
+	case p2p.EventHandshakeRejected:
+		return r.store.RecordRejection(event.NodeInfo, &Rejection{
+			Time:       event.Time,
+			Reason:     event.Err.Error(),
+			RemoteAddr: event.NodeInfo.RemoteAddr,
+			Outbound:   event.Outbound,
+		})
+	case p2p.EventAddrsReceived:
+		return r.store.RecordAdvertised(event.NodeInfo, event.Addrs, event.Time)
+	case p2p.EventPeerAdded:
+		return r.store.RecordConnect(event.NodeInfo, event.Time)
+	case p2p.EventPeerRemoved:
+		conn := &ConnRecord{
+			PubKey:     event.NodeInfo.PubKey.KeyString(),
+			RemoteAddr: event.NodeInfo.RemoteAddr,
+			Outbound:   event.Outbound,
+			Start:      event.Time.Add(-event.Duration),
+			End:        event.Time,
+			Duration:   event.Duration,
+		}
+		if event.Reason != nil {
+			conn.Reason = fmt.Sprint(event.Reason)
+		}
+		if event.Ping != nil {
+			conn.MinRTT, conn.AvgRTT, conn.MaxRTT = event.Ping.MinRTT, event.Ping.AvgRTT, event.Ping.MaxRTT
+		}
+		return r.store.RecordDisconnect(conn)
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/nodestats/geoip"
+	"github.com/nodestats/p2p"
+)
+
+var (
+	nodePrefix = []byte("node:")
+	connPrefix = []byte("conn:")
+	addrPrefix = []byte("addr:")
+	advPrefix  = []byte("adv:")
+)
+
+// ErrNotFound is returned when a record is not in the store
+var ErrNotFound = errors.New("record not found")
+
+func nodeKey(pubKey string) []byte {
+	return []byte(fmt.Sprintf("%s%s", nodePrefix, pubKey))
+}
+
+func connPubKeyPrefix(pubKey string) []byte {
+	return []byte(fmt.Sprintf("%s%s:", connPrefix, pubKey))
+}
+
+// conn keys are zero padded so a prefix scan returns them in time order
+func connKey(pubKey string, start time.Time) []byte {
+	return []byte(fmt.Sprintf("%s%s:%020d", connPrefix, pubKey, start.UnixNano()))
+}
+
+func addrKey(addr string) []byte {
+	return []byte(fmt.Sprintf("%s%s", addrPrefix, addr))
+}
+
+func advKey(pubKey string) []byte {
+	return []byte(fmt.Sprintf("%s%s", advPrefix, pubKey))
+}
+
+// Store persists the history of every node the crawler has observed.
+type Store struct {
+	mtx sync.Mutex
+	db  dbm.DB
+	geo *geoip.Resolver // locates new IPs when set
+}
+
+// NewStore creates a node store on top of the given database
+func NewStore(db dbm.DB) *Store {
+	return &Store{db: db}
+}
+
+// RecordConnect stores a successful handshake with a node.
+func (s *Store) RecordConnect(info *p2p.NodeInfo, now time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	pubKey := info.PubKey.KeyString()
+	node, err := s.getNode(pubKey)
+	if err == ErrNotFound {
+		node = &NodeRecord{PubKey: pubKey}
+	} else if err != nil {
+		return err
+	}
+
+	node.seen(now)
+	node.addInfo(newInfoRevision(info, now))
+	node.addIP(info.RemoteAddrHost(), now)
+	return s.saveNode(node)
+}
+
+// RecordRejection stores the NodeInfo of a node whose handshake was rejected
+// as incompatible, along with the reason.
+func (s *Store) RecordRejection(info *p2p.NodeInfo, rejection *Rejection) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	pubKey := info.PubKey.KeyString()
+	node, err := s.getNode(pubKey)
+	if err == ErrNotFound {
+		node = &NodeRecord{PubKey: pubKey}
+	} else if err != nil {
+		return err
+	}
+
+	node.seen(rejection.Time)
+	node.addInfo(newInfoRevision(info, rejection.Time))
+	node.addIP(info.RemoteAddrHost(), rejection.Time)
+	node.addRejection(rejection)
+	return s.saveNode(node)
+}
+
+// RecordDisconnect stores a finished connection with a node.
+func (s *Store) RecordDisconnect(conn *ConnRecord) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	node, err := s.getNode(conn.PubKey)
+	if err == ErrNotFound {
+		node = &NodeRecord{PubKey: conn.PubKey}
+	} else if err != nil {
+		return err
+	}
+
+	node.seen(conn.Start)
+	node.seen(conn.End)
+	if err := s.saveNode(node); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(conn)
+	if err != nil {
+		return err
+	}
+	s.db.Set(connKey(conn.PubKey, conn.Start), data)
+	return nil
+}
+
+// RecordReachability stores the result of a dial to the address.
+func (s *Store) RecordReachability(addr *p2p.NetAddress, dialErr error, now time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	record, err := s.getAddr(addr.String())
+	if err == ErrNotFound {
+		record = &AddrRecord{Addr: addr.String(), FirstSeen: now}
+	} else if err != nil {
+		return err
+	}
+
+	record.recordAttempt(dialErr == nil, now)
+	record.Attempts++
+	record.LastAttempt = now
+	if dialErr != nil {
+		record.LastError = dialErr.Error()
+	} else {
+		record.Successes++
+		record.LastSuccess = now
+		record.LastError = ""
+	}
+	return s.saveAddr(record)
+}
+
+// RecordAdvertised stores the addresses a node answered a PEX request with.
+func (s *Store) RecordAdvertised(info *p2p.NodeInfo, addrs []*p2p.NetAddress, now time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	pubKey := info.PubKey.KeyString()
+	record := &AdvertRecord{PubKey: pubKey}
+	if data := s.db.Get(advKey(pubKey)); data != nil {
+		if err := json.Unmarshal(data, record); err != nil {
+			return errors.Wrapf(err, "decode advert record %s", pubKey)
+		}
+	}
+
+	record.RemoteAddr = info.RemoteAddr
+	record.LastSeen = now
+	for _, addr := range addrs {
+		record.addAddr(addr.String(), now)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.db.Set(advKey(pubKey), data)
+	return nil
+}
+
+// ListAdvertised returns the PEX responses of every node that answered one
+func (s *Store) ListAdvertised() ([]*AdvertRecord, error) {
+	records := []*AdvertRecord{}
+	iter := s.db.IteratorPrefix(advPrefix)
+	defer iter.Release()
+
+	for iter.Next() {
+		record := &AdvertRecord{}
+		if err := json.Unmarshal(iter.Value(), record); err != nil {
+			return nil, errors.Wrapf(err, "decode advert record %s", iter.Key())
+		}
+		records = append(records, record)
+	}
+	return records, iter.Error()
+}
+
+// GetAddr returns the reachability record of the given address
+func (s *Store) GetAddr(addr string) (*AddrRecord, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.getAddr(addr)
+}
+
+// ListAddrs returns the reachability records of every address ever dialed
+func (s *Store) ListAddrs() ([]*AddrRecord, error) {
+	addrs := []*AddrRecord{}
+	iter := s.db.IteratorPrefix(addrPrefix)
+	defer iter.Release()
+
+	for iter.Next() {
+		record := &AddrRecord{}
+		if err := json.Unmarshal(iter.Value(), record); err != nil {
+			return nil, errors.Wrapf(err, "decode address record %s", iter.Key())
+		}
+		addrs = append(addrs, record)
+	}
+	return addrs, iter.Error()
+}
+
+// GetNode returns the record of the node with the given pubkey
+func (s *Store) GetNode(pubKey string) (*NodeRecord, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.getNode(pubKey)
+}
+
+// ListNodes returns the records of every node ever observed
+func (s *Store) ListNodes() ([]*NodeRecord, error) {
+	nodes := []*NodeRecord{}
+	iter := s.db.IteratorPrefix(nodePrefix)
+	defer iter.Release()
+
+	for iter.Next() {
+		node := &NodeRecord{}
+		if err := json.Unmarshal(iter.Value(), node); err != nil {
+			return nil, errors.Wrapf(err, "decode node record %s", iter.Key())
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, iter.Error()
+}
+
+// ListConnections returns the finished connections of a node, oldest first
+func (s *Store) ListConnections(pubKey string) ([]*ConnRecord, error) {
+	conns := []*ConnRecord{}
+	iter := s.db.IteratorPrefix(connPubKeyPrefix(pubKey))
+	defer iter.Release()
+
+	for iter.Next() {
+		conn := &ConnRecord{}
+		if err := json.Unmarshal(iter.Value(), conn); err != nil {
+			return nil, errors.Wrapf(err, "decode connection record %s", iter.Key())
+		}
+		conns = append(conns, conn)
+	}
+	return conns, iter.Error()
+}
+
+func (s *Store) getNode(pubKey string) (*NodeRecord, error) {
+	data := s.db.Get(nodeKey(pubKey))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	node := &NodeRecord{}
+	if err := json.Unmarshal(data, node); err != nil {
+		return nil, errors.Wrapf(err, "decode node record %s", pubKey)
+	}
+	return node, nil
+}
+
+func (s *Store) saveNode(node *NodeRecord) error {
+	if s.geo != nil {
+		node.enrich(s.geo, false)
+	}
+	data, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	s.db.Set(nodeKey(node.PubKey), data)
+	return nil
+}
+
+func (s *Store) getAddr(addr string) (*AddrRecord, error) {
+	data := s.db.Get(addrKey(addr))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	record := &AddrRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, errors.Wrapf(err, "decode address record %s", addr)
+	}
+	return record, nil
+}
+
+func (s *Store) saveAddr(record *AddrRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.db.Set(addrKey(record.Addr), data)
+	return nil
+}
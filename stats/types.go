@@ -0,0 +1,169 @@
+package stats
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/nodestats/geoip"
+	"github.com/nodestats/p2p"
+)
+
+const maxRejections = 32
+
+// NodeRecord is everything the crawler has learned about a node, keyed by its pubkey.
+type NodeRecord struct {
+	PubKey     string          `json:"pub_key"`
+	FirstSeen  time.Time       `json:"first_seen"`
+	LastSeen   time.Time       `json:"last_seen"`
+	Infos      []*InfoRevision `json:"infos"`
+	IPs        []*IPRecord     `json:"ips"`
+	Rejections []*Rejection    `json:"rejections,omitempty"`
+}
+
+// InfoRevision is one distinct NodeInfo announced by a node.
+type InfoRevision struct {
+	Time       time.Time `json:"time"`
+	Moniker    string    `json:"moniker"`
+	Network    string    `json:"network"`
+	Version    string    `json:"version"`
+	ListenAddr string    `json:"listen_addr"`
+	Other      []string  `json:"other"`
+}
+
+// IPRecord is an IP a node has connected from or been reached at.
+type IPRecord struct {
+	IP        string          `json:"ip"`
+	FirstSeen time.Time       `json:"first_seen"`
+	LastSeen  time.Time       `json:"last_seen"`
+	Geo       *geoip.Location `json:"geo,omitempty"` // nil until the IP is located
+}
+
+// Rejection is a handshake refused because the node is not compatible with us.
+type Rejection struct {
+	Time       time.Time `json:"time"`
+	Reason     string    `json:"reason"`
+	RemoteAddr string    `json:"remote_addr"`
+	Outbound   bool      `json:"outbound"`
+}
+
+// ConnRecord is a single finished connection with a node.
+type ConnRecord struct {
+	PubKey     string        `json:"pub_key"`
+	RemoteAddr string        `json:"remote_addr"`
+	Outbound   bool          `json:"outbound"`
+	Start      time.Time     `json:"start"`
+	End        time.Time     `json:"end"`
+	Duration   time.Duration `json:"duration"`
+	Reason     string        `json:"reason,omitempty"`
+	MinRTT     time.Duration `json:"min_rtt,omitempty"` // ping round trip times, zero when no pong arrived
+	AvgRTT     time.Duration `json:"avg_rtt,omitempty"`
+	MaxRTT     time.Duration `json:"max_rtt,omitempty"`
+}
+
+// AdvertRecord is the set of addresses a node sent us in its PEX responses.
+type AdvertRecord struct {
+	PubKey     string            `json:"pub_key"`
+	RemoteAddr string            `json:"remote_addr"`
+	LastSeen   time.Time         `json:"last_seen"`
+	Addrs      []*AdvertisedAddr `json:"addrs"`
+}
+
+// AdvertisedAddr is an address a node advertised, with the first and last time it did.
+type AdvertisedAddr struct {
+	Addr      string    `json:"addr"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// AddrRecord is the reachability history of a dialable address.
+type AddrRecord struct {
+	Addr        string    `json:"addr"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success"`
+	Attempts    int64     `json:"attempts"`
+	Successes   int64     `json:"successes"`
+	LastError   string    `json:"last_error,omitempty"`
+
+	Availability Availability `json:"availability,omitempty"`
+}
+
+// Reachable reports whether the latest dial to the address succeeded.
+func (a *AddrRecord) Reachable() bool {
+	return !a.LastSuccess.IsZero() && !a.LastSuccess.Before(a.LastAttempt)
+}
+
+func newInfoRevision(info *p2p.NodeInfo, now time.Time) *InfoRevision {
+	return &InfoRevision{
+		Time:       now,
+		Moniker:    info.Moniker,
+		Network:    info.Network,
+		Version:    info.Version,
+		ListenAddr: info.ListenAddr,
+		Other:      info.Other,
+	}
+}
+
+func (r *InfoRevision) sameAs(o *InfoRevision) bool {
+	return r.Moniker == o.Moniker &&
+		r.Network == o.Network &&
+		r.Version == o.Version &&
+		r.ListenAddr == o.ListenAddr &&
+		reflect.DeepEqual(r.Other, o.Other)
+}
+
+// LatestInfo returns the most recent NodeInfo revision, or nil if none was recorded.
+func (n *NodeRecord) LatestInfo() *InfoRevision {
+	if len(n.Infos) == 0 {
+		return nil
+	}
+	return n.Infos[len(n.Infos)-1]
+}
+
+// addInfo appends the revision unless it matches the latest one.
+func (n *NodeRecord) addInfo(rev *InfoRevision) {
+	if latest := n.LatestInfo(); latest != nil && latest.sameAs(rev) {
+		return
+	}
+	n.Infos = append(n.Infos, rev)
+}
+
+func (n *NodeRecord) addIP(ip string, now time.Time) {
+	if ip == "" {
+		return
+	}
+	for _, r := range n.IPs {
+		if r.IP == ip {
+			r.LastSeen = now
+			return
+		}
+	}
+	n.IPs = append(n.IPs, &IPRecord{IP: ip, FirstSeen: now, LastSeen: now})
+}
+
+// addRejection appends the rejection, only the latest maxRejections are kept.
+func (n *NodeRecord) addRejection(r *Rejection) {
+	n.Rejections = append(n.Rejections, r)
+	if len(n.Rejections) > maxRejections {
+		n.Rejections = n.Rejections[len(n.Rejections)-maxRejections:]
+	}
+}
+
+func (a *AdvertRecord) addAddr(addr string, now time.Time) {
+	for _, r := range a.Addrs {
+		if r.Addr == addr {
+			r.LastSeen = now
+			return
+		}
+	}
+	a.Addrs = append(a.Addrs, &AdvertisedAddr{Addr: addr, FirstSeen: now, LastSeen: now})
+}
+
+func (n *NodeRecord) seen(now time.Time) {
+	if n.FirstSeen.IsZero() || now.Before(n.FirstSeen) {
+		n.FirstSeen = now
+	}
+	if now.After(n.LastSeen) {
+		n.LastSeen = now
+	}
+}
@@ -0,0 +1,226 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nodestats/p2p"
+)
+
+const day = 24 * time.Hour
+
+// VersionReportOptions selects the time ranges of a version report
+type VersionReportOptions struct {
+	Now      time.Time
+	Network  string        // only nodes last seen on this network count, empty counts every node
+	Active   time.Duration // nodes seen within Active count in the current distribution
+	Days     int           // days of history
+	MinMajor uint64        // majors below are deprecated, 0 uses the highest current major
+}
+
+// DefaultVersionReportOptions returns the options used when none are given
+func DefaultVersionReportOptions() *VersionReportOptions {
+	return &VersionReportOptions{
+		Now:    time.Now(),
+		Active: day,
+		Days:   90,
+	}
+}
+
+// VersionCount is the number of distinct nodes running a version
+type VersionCount struct {
+	Version string  `json:"version"`
+	Nodes   int     `json:"nodes"`
+	Percent float64 `json:"percent"`
+}
+
+// DailyVersions is the version distribution of one day
+type DailyVersions struct {
+	Day      time.Time      `json:"day"`
+	Total    int            `json:"total"`
+	Versions map[string]int `json:"versions"`
+}
+
+// AdoptionPoint is the share of nodes on a version some days after it first appeared
+type AdoptionPoint struct {
+	Day     int     `json:"day"`
+	Nodes   int     `json:"nodes"`
+	Percent float64 `json:"percent"`
+}
+
+// AdoptionCurve follows a version from the first day any node announced it
+type AdoptionCurve struct {
+	Version   string           `json:"version"`
+	FirstSeen time.Time        `json:"first_seen"`
+	Points    []*AdoptionPoint `json:"points"`
+}
+
+// VersionReport answers which versions the network runs and how fast it upgrades
+type VersionReport struct {
+	Generated         time.Time        `json:"generated"`
+	Total             int              `json:"total"`
+	Current           []*VersionCount  `json:"current"`
+	History           []*DailyVersions `json:"history"`
+	Adoption          []*AdoptionCurve `json:"adoption"`
+	MinMajor          uint64           `json:"min_major"`
+	Deprecated        int              `json:"deprecated"`
+	DeprecatedPercent float64          `json:"deprecated_percent"`
+}
+
+// BuildVersionReport computes the version report from the node records.
+// History is an approximation, a node counts on every day between its first
+// and last sighting with the version it announced that day.
+func BuildVersionReport(nodes []*NodeRecord, opts *VersionReportOptions) *VersionReport {
+	report := &VersionReport{
+		Generated: opts.Now,
+		Current:   []*VersionCount{},
+		History:   []*DailyVersions{},
+		Adoption:  []*AdoptionCurve{},
+	}
+
+	today := opts.Now.UTC().Truncate(day)
+	firstDay := today.Add(-time.Duration(opts.Days-1) * day)
+	for d := firstDay; !d.After(today); d = d.Add(day) {
+		report.History = append(report.History, &DailyVersions{Day: d, Versions: make(map[string]int)})
+	}
+
+	current := make(map[string]int)
+	firstSeen := make(map[string]time.Time)
+	for _, node := range nodes {
+		info := node.LatestInfo()
+		if opts.Network != "" && (info == nil || info.Network != opts.Network) {
+			continue
+		}
+		for v, t := range versionFirstSeen(node) {
+			if first, ok := firstSeen[v]; !ok || t.Before(first) {
+				firstSeen[v] = t
+			}
+		}
+		for i, v := range nodeDailyVersions(node, firstDay, len(report.History)) {
+			if v != "" {
+				report.History[i].Versions[v]++
+				report.History[i].Total++
+			}
+		}
+
+		if info == nil || opts.Now.Sub(node.LastSeen) > opts.Active {
+			continue
+		}
+		current[p2p.NormalizeVersion(info.Version)]++
+		report.Total++
+	}
+
+	for v, n := range current {
+		report.Current = append(report.Current, &VersionCount{Version: v, Nodes: n, Percent: percent(n, report.Total)})
+	}
+	sort.Slice(report.Current, func(i, j int) bool {
+		return compareVersionStrings(report.Current[i].Version, report.Current[j].Version) > 0
+	})
+
+	report.MinMajor = opts.MinMajor
+	if report.MinMajor == 0 {
+		report.MinMajor = highestMajor(report.Current)
+	}
+	for _, c := range report.Current {
+		if v, err := p2p.ParseVersion(c.Version); err == nil && v.Major < report.MinMajor {
+			report.Deprecated += c.Nodes
+		}
+	}
+	report.DeprecatedPercent = percent(report.Deprecated, report.Total)
+
+	report.Adoption = adoptionCurves(report.History, firstSeen, firstDay)
+	return report
+}
+
+// versionFirstSeen returns when the node first announced each version
+func versionFirstSeen(node *NodeRecord) map[string]time.Time {
+	seen := make(map[string]time.Time)
+	for _, rev := range node.Infos {
+		v := p2p.NormalizeVersion(rev.Version)
+		if first, ok := seen[v]; !ok || rev.Time.Before(first) {
+			seen[v] = rev.Time
+		}
+	}
+	return seen
+}
+
+// nodeDailyVersions returns the version the node ran on each day starting at
+// firstDay, or "" for the days it wasn't around.
+func nodeDailyVersions(node *NodeRecord, firstDay time.Time, days int) []string {
+	result := make([]string, days)
+	for i, rev := range node.Infos {
+		end := node.LastSeen
+		if i+1 < len(node.Infos) {
+			end = node.Infos[i+1].Time
+		}
+
+		v := p2p.NormalizeVersion(rev.Version)
+		for d := dayIndex(rev.Time, firstDay); d <= dayIndex(end, firstDay) && d < days; d++ {
+			if d >= 0 {
+				result[d] = v
+			}
+		}
+	}
+	return result
+}
+
+func dayIndex(t, firstDay time.Time) int {
+	return int(t.UTC().Truncate(day).Sub(firstDay) / day)
+}
+
+// adoptionCurves follows every version that first appeared inside the history
+func adoptionCurves(history []*DailyVersions, firstSeen map[string]time.Time, firstDay time.Time) []*AdoptionCurve {
+	curves := []*AdoptionCurve{}
+	for v, first := range firstSeen {
+		start := dayIndex(first, firstDay)
+		if start < 0 || start >= len(history) {
+			continue
+		}
+
+		curve := &AdoptionCurve{Version: v, FirstSeen: first, Points: []*AdoptionPoint{}}
+		for i := start; i < len(history); i++ {
+			n := history[i].Versions[v]
+			curve.Points = append(curve.Points, &AdoptionPoint{Day: i - start, Nodes: n, Percent: percent(n, history[i].Total)})
+		}
+		curves = append(curves, curve)
+	}
+	sort.Slice(curves, func(i, j int) bool { return curves[i].FirstSeen.Before(curves[j].FirstSeen) })
+	return curves
+}
+
+func highestMajor(counts []*VersionCount) uint64 {
+	var major uint64
+	for _, c := range counts {
+		if v, err := p2p.ParseVersion(c.Version); err == nil && v.Major > major {
+			major = v.Major
+		}
+	}
+	return major
+}
+
+// compareVersionStrings orders by semver precedence, unparsable versions sort last
+func compareVersionStrings(a, b string) int {
+	va, aErr := p2p.ParseVersion(a)
+	vb, bErr := p2p.ParseVersion(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return va.Compare(vb)
+	case aErr == nil:
+		return 1
+	case bErr == nil:
+		return -1
+	}
+	if a > b {
+		return -1
+	} else if a < b {
+		return 1
+	}
+	return 0
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}